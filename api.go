@@ -1,55 +1,143 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
-func callAPI(cfg Config, system string, history []Message) (string, error) {
-	url := cfg.APIURL
-	messages := []Message{{Role: "system", Content: system}}
-	messages = append(messages, history...)
-	
-	reqBody, _ := json.Marshal(map[string]interface{}{
-		"model":    cfg.Model,
-		"messages": messages,
-	})
-	
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+// resolveEndpoint returns the URL to call for the given provider: the
+// SHREW_API_URL override when it is meant for that provider, the registry
+// endpoint otherwise, with the model name substituted for providers that
+// embed it in the path.
+func resolveEndpoint(cfg Config, provider, model string) string {
+	endpoint := cfg.APIURL
+	if endpoint == "" || apiURLProvider(endpoint, provider) != provider {
+		if p, ok := findProvider(provider); ok {
+			endpoint = p.Endpoint
+		}
+	}
+	if strings.Contains(endpoint, "%s") {
+		endpoint = fmt.Sprintf(endpoint, model)
+	}
+	return endpoint
+}
+
+// apiURLProvider returns the provider an API URL belongs to. A URL on the
+// host of another provider's registry endpoint, such as an OpenAI URL left in
+// .env after switching to a gemini/ model, belongs to that provider; any
+// other URL, a proxy or a self-hosted server, configures the one in use.
+func apiURLProvider(apiURL, inUse string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "" {
+		return inUse
+	}
+	if p, ok := findProvider(inUse); ok && endpointHost(p.Endpoint) == u.Host {
+		return inUse
+	}
+	for _, p := range ModelRegistry {
+		if endpointHost(p.Endpoint) == u.Host {
+			return p.ID
+		}
+	}
+	return inUse
+}
+
+func endpointHost(endpoint string) string {
+	u, err := url.Parse(endpoint)
 	if err != nil {
-		return "", err
+		return ""
 	}
-	
-	req.Header.Set("Content-Type", "application/json")
-	if cfg.APIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	return u.Host
+}
+
+// doJSON sends body (if any) as JSON and returns the response once it is
+// known to be successful. Non-2xx responses are turned into an error carrying
+// the body, which is where providers put their error messages.
+func doJSON(ctx context.Context, method, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
 	}
-	
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
 	resp, err := (&http.Client{}).Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
 		b, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("api error (%d): %s", resp.StatusCode, string(b))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(b)}
 	}
-	
-	var result struct {
-		Choices []struct {
-			Message Message `json:"message"`
-		} `json:"choices"`
+	return resp, nil
+}
+
+// APIError is a non-2xx response from a provider.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error (%d): %s", e.StatusCode, e.Body)
+}
+
+// readSSE parses a server-sent events stream, calling fn with the event name
+// and data of each event. It stops at the end of the stream, when fn returns
+// an error, or at the "[DONE]" sentinel used by OpenAI-compatible servers.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				payload := strings.Join(data, "\n")
+				if payload == "[DONE]" {
+					return nil
+				}
+				if err := fn(event, payload); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// Comment, used as a keep-alive.
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+	if err := scanner.Err(); err != nil {
+		return err
 	}
-	
-	if len(result.Choices) > 0 {
-		return result.Choices[0].Message.Content, nil
+	if len(data) > 0 && strings.Join(data, "\n") != "[DONE]" {
+		return fn(event, strings.Join(data, "\n"))
 	}
-	return "", fmt.Errorf("no response")
+	return nil
 }
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestResolveEndpoint(t *testing.T) {
+	const openaiURL = "https://api.openai.com/v1/chat/completions"
+	tests := []struct {
+		apiURL, provider, model string
+		want                    string
+	}{
+		{"", "openai", "gpt-4o", openaiURL},
+		{openaiURL, "openai", "gpt-4o", openaiURL},
+		{openaiURL, "gemini", "gemini-2.0-flash", "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:generateContent"},
+		{openaiURL, "anthropic", "claude-3-opus-20240229", "https://api.anthropic.com/v1/messages"},
+		{"https://proxy.example/v1/chat/completions", "openai", "gpt-4o", "https://proxy.example/v1/chat/completions"},
+		{"http://gpu-box:11434/api/chat", "ollama", "llama3.1", "http://gpu-box:11434/api/chat"},
+		{"https://api.anthropic.com/v1/messages", "openai", "gpt-4o", openaiURL},
+	}
+	for _, tt := range tests {
+		got := resolveEndpoint(Config{APIURL: tt.apiURL}, tt.provider, tt.model)
+		if got != tt.want {
+			t.Errorf("resolveEndpoint(%q, %s) = %q, want %q", tt.apiURL, tt.provider, got, tt.want)
+		}
+	}
+}
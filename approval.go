@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ApprovalDecision is a user's answer to an approval request. Command, when
+// set, replaces the command that was proposed; Always approves the same
+// command for the rest of the session without asking again.
+type ApprovalDecision struct {
+	Approved bool   `json:"approved"`
+	Always   bool   `json:"always"`
+	Command  string `json:"command,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ApprovalReveal is the kind of an approval request to show a secret to the
+// model; Command then holds the secret's name. Requests for commands have no
+// kind.
+const ApprovalReveal = "reveal"
+
+// PendingApproval is a command waiting for the user.
+type PendingApproval struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	Kind    string `json:"kind,omitempty"`
+
+	ch chan ApprovalDecision
+}
+
+// requestApproval asks the user whether command may run, or for another kind
+// of request what it concerns, and blocks until the terminal or the Web UI
+// answers, or the turn is cancelled. Requests previously approved with
+// "always" go through without asking.
+func (e *Engine) requestApproval(ctx context.Context, kind, command string) ApprovalDecision {
+	e.mu.Lock()
+	if e.alwaysApproved[kind+"\x00"+command] {
+		e.mu.Unlock()
+		return ApprovalDecision{Approved: true}
+	}
+	e.nextApprovalID++
+	p := &PendingApproval{
+		ID:      strconv.Itoa(e.nextApprovalID),
+		Command: command,
+		Kind:    kind,
+		ch:      make(chan ApprovalDecision, 1),
+	}
+	e.approvals[p.ID] = p
+	e.mu.Unlock()
+
+	e.broadcast(Event{Type: EventApproval, ID: p.ID, Content: command, Kind: kind})
+	select {
+	case d := <-p.ch:
+		return d
+	case <-ctx.Done():
+		e.mu.Lock()
+		delete(e.approvals, p.ID)
+		e.mu.Unlock()
+		e.broadcast(Event{Type: EventApprovalResolved, ID: p.ID, Content: "cancelled"})
+		return ApprovalDecision{Reason: "the turn was cancelled"}
+	}
+}
+
+// ResolveApproval delivers the user's decision for a pending request.
+func (e *Engine) ResolveApproval(id string, d ApprovalDecision) error {
+	e.mu.Lock()
+	p, ok := e.approvals[id]
+	if !ok {
+		e.mu.Unlock()
+		return fmt.Errorf("no pending approval with id %s", id)
+	}
+	delete(e.approvals, id)
+	if p.Kind != "" {
+		// Only commands can be edited.
+		d.Command = ""
+	}
+	if d.Approved && d.Always {
+		command := p.Command
+		if d.Command != "" {
+			command = d.Command
+		}
+		e.alwaysApproved[p.Kind+"\x00"+command] = true
+	}
+	e.mu.Unlock()
+
+	p.ch <- d
+	verdict := "rejected"
+	if d.Approved {
+		verdict = "approved"
+	}
+	e.broadcast(Event{Type: EventApprovalResolved, ID: id, Content: verdict})
+	return nil
+}
+
+// PendingApprovals lists the requests still waiting for an answer, oldest
+// first.
+func (e *Engine) PendingApprovals() []PendingApproval {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	pending := []PendingApproval{}
+	for _, p := range e.approvals {
+		pending = append(pending, PendingApproval{ID: p.ID, Command: p.Command, Kind: p.Kind})
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		a, _ := strconv.Atoi(pending[i].ID)
+		b, _ := strconv.Atoi(pending[j].ID)
+		return a < b
+	})
+	return pending
+}
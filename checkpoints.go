@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CheckpointTurn sums up the file changes one turn made, as offered for undo.
+type CheckpointTurn struct {
+	Turn   int      `json:"turn"`
+	Prompt string   `json:"prompt"` // the user message that started the turn
+	Time   string   `json:"time"`
+	Files  []string `json:"files"`
+}
+
+// checkpoint records the current content of a file a file action is about to
+// change, so the turn can be undone. A file that does not exist yet is
+// recorded as such and removed on undo.
+func (e *Engine) checkpoint(abs string) error {
+	c := Checkpoint{Path: abs, Time: time.Now().Format(time.RFC3339)}
+	info, err := os.Stat(abs)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case info.IsDir():
+		return fmt.Errorf("%s is a directory", abs)
+	default:
+		if c.Content, err = os.ReadFile(abs); err != nil {
+			return err
+		}
+		c.Existed, c.Mode = true, uint32(info.Mode().Perm())
+	}
+
+	e.mu.Lock()
+	c.Turn = e.turn
+	sessionID := e.SessionID
+	e.mu.Unlock()
+	if err := e.DB.SaveCheckpoint(sessionID, c); err != nil {
+		return fmt.Errorf("saving checkpoint: %v", err)
+	}
+	return nil
+}
+
+// Checkpoints lists the turns of the session that changed files, oldest
+// first.
+func (e *Engine) Checkpoints() ([]CheckpointTurn, error) {
+	e.mu.Lock()
+	sessionID := e.SessionID
+	history := e.History
+	e.mu.Unlock()
+	checkpoints, err := e.DB.ListCheckpoints(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var turns []CheckpointTurn
+	seen := map[string]bool{}
+	for _, c := range checkpoints {
+		if len(turns) == 0 || turns[len(turns)-1].Turn != c.Turn {
+			prompt := ""
+			if c.Turn < len(history) {
+				prompt = firstLine(history[c.Turn].Content)
+			}
+			turns = append(turns, CheckpointTurn{Turn: c.Turn, Prompt: prompt, Time: c.Time})
+			seen = map[string]bool{}
+		}
+		if !seen[c.Path] {
+			seen[c.Path] = true
+			t := &turns[len(turns)-1]
+			t.Files = append(t.Files, e.relPath(c.Path))
+		}
+	}
+	return turns, nil
+}
+
+// Undo restores every file changed since turn to the content it had before,
+// newest change first, and removes the files created since. The checkpoints
+// are only dropped once all files are restored, so a failed undo can be
+// retried. It returns the restored files.
+func (e *Engine) Undo(turn int) ([]string, error) {
+	e.mu.Lock()
+	busy := e.cancelTurn != nil
+	sessionID := e.SessionID
+	e.mu.Unlock()
+	if busy {
+		return nil, fmt.Errorf("a turn is in progress; stop it before undoing")
+	}
+	checkpoints, err := e.DB.ListCheckpoints(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var restored, failed []string
+	seen := map[string]bool{}
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		c := checkpoints[i]
+		if c.Turn < turn {
+			continue
+		}
+		if err := restoreCheckpoint(c); err != nil {
+			failed = append(failed, err.Error())
+		} else if !seen[c.Path] {
+			seen[c.Path] = true
+			restored = append(restored, e.relPath(c.Path))
+		}
+	}
+	if len(failed) > 0 {
+		return restored, fmt.Errorf("could not restore: %s", strings.Join(failed, "; "))
+	}
+	if len(restored) == 0 {
+		return nil, fmt.Errorf("no file changes to undo")
+	}
+	if err := e.DB.DeleteCheckpoints(sessionID, turn); err != nil {
+		return restored, err
+	}
+	sort.Strings(restored)
+	e.broadcast(Event{Type: EventFileOp, Content: fmt.Sprintf("Undid file changes, restored: %s", strings.Join(restored, ", "))})
+	return restored, nil
+}
+
+func restoreCheckpoint(c Checkpoint) error {
+	if !c.Existed {
+		if err := os.Remove(c.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.Path, c.Content, fs.FileMode(c.Mode)); err != nil {
+		return err
+	}
+	// WriteFile only applies the mode to new files.
+	return os.Chmod(c.Path, fs.FileMode(c.Mode))
+}
+
+// relPath shows a path relative to the workspace root when it is inside it.
+func (e *Engine) relPath(abs string) string {
+	e.mu.Lock()
+	root := e.Shell.Root
+	e.mu.Unlock()
+	if rel, err := filepath.Rel(root, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return abs
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"go.etcd.io/bbolt"
@@ -11,6 +12,12 @@ var (
 	bucketSessions = []byte("Sessions")
 	bucketVault    = []byte("Vault")
 	bucketSkills   = []byte("Skills")
+	// Checkpoints holds one nested bucket per session, keyed by sequence.
+	bucketCheckpoints = []byte("Checkpoints")
+	bucketVaultConfig = []byte("VaultConfig")
+	bucketVaultMeta   = []byte("VaultMeta")
+
+	vaultConfigKey = []byte("config")
 )
 
 type DB struct {
@@ -33,6 +40,18 @@ func InitDB(path string) (*DB, error) {
 			return err
 		}
 		_, err = tx.CreateBucketIfNotExists(bucketSkills)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(bucketCheckpoints)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(bucketVaultConfig)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(bucketVaultMeta)
 		return err
 	})
 
@@ -75,7 +94,14 @@ func (db *DB) GetSession(id string) (Session, error) {
 func (db *DB) DeleteSession(id string) error {
 	return db.conn.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(bucketSessions)
-		return b.Delete([]byte(id))
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+		err := tx.Bucket(bucketCheckpoints).DeleteBucket([]byte(id))
+		if err == bbolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
 	})
 }
 
@@ -95,34 +121,106 @@ func (db *DB) ListSessions() ([]Session, error) {
 	return sessions, err
 }
 
-// Vault Operations
-func (db *DB) SaveSecret(key, value string) error {
+// Checkpoint Operations
+func (db *DB) SaveCheckpoint(sessionID string, c Checkpoint) error {
+	return db.conn.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(bucketCheckpoints).CreateBucketIfNotExists([]byte(sessionID))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, data)
+	})
+}
+
+// ListCheckpoints returns a session's checkpoints in the order they were
+// taken.
+func (db *DB) ListCheckpoints(sessionID string) ([]Checkpoint, error) {
+	var checkpoints []Checkpoint
+	err := db.conn.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketCheckpoints).Bucket([]byte(sessionID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var c Checkpoint
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			checkpoints = append(checkpoints, c)
+			return nil
+		})
+	})
+	return checkpoints, err
+}
+
+// DeleteCheckpoints drops a session's checkpoints from turn onwards.
+func (db *DB) DeleteCheckpoints(sessionID string, turn int) error {
+	return db.conn.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketCheckpoints).Bucket([]byte(sessionID))
+		if b == nil {
+			return nil
+		}
+		var stale [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var c Checkpoint
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			if c.Turn >= turn {
+				stale = append(stale, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Vault Operations. Values are stored as given; the Vault encrypts them.
+func (db *DB) SaveSecret(key string, value []byte) error {
 	return db.conn.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(bucketVault)
-		return b.Put([]byte(key), []byte(value))
+		return b.Put([]byte(key), value)
 	})
 }
 
-func (db *DB) GetSecret(key string) (string, error) {
-	var val string
+func (db *DB) GetSecret(key string) ([]byte, error) {
+	var val []byte
 	err := db.conn.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(bucketVault)
 		data := b.Get([]byte(key))
 		if data == nil {
 			return fmt.Errorf("secret not found")
 		}
-		val = string(data)
+		val = append([]byte{}, data...)
 		return nil
 	})
 	return val, err
 }
 
-func (db *DB) ListSecrets() (map[string]string, error) {
-	secrets := make(map[string]string)
+func (db *DB) ListSecrets() (map[string][]byte, error) {
+	secrets := make(map[string][]byte)
 	err := db.conn.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(bucketVault)
 		return b.ForEach(func(k, v []byte) error {
-			secrets[string(k)] = string(v)
+			secrets[string(k)] = append([]byte{}, v...)
 			return nil
 		})
 	})
@@ -131,11 +229,119 @@ func (db *DB) ListSecrets() (map[string]string, error) {
 
 func (db *DB) DeleteSecret(key string) error {
 	return db.conn.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(bucketVaultMeta).Delete([]byte(key)); err != nil {
+			return err
+		}
 		b := tx.Bucket(bucketVault)
 		return b.Delete([]byte(key))
 	})
 }
 
+// UpdateSecretInfo changes the metadata of a secret through update. A secret
+// without metadata starts from its name only.
+func (db *DB) UpdateSecretInfo(key string, update func(info *SecretInfo)) error {
+	return db.conn.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketVaultMeta)
+		info := SecretInfo{Name: key}
+		if data := b.Get([]byte(key)); data != nil {
+			if err := json.Unmarshal(data, &info); err != nil {
+				return err
+			}
+		}
+		update(&info)
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// GetSecretInfo returns the metadata of a secret, or just its name when it
+// has none.
+func (db *DB) GetSecretInfo(key string) (SecretInfo, error) {
+	info := SecretInfo{Name: key}
+	err := db.conn.View(func(tx *bbolt.Tx) error {
+		if data := tx.Bucket(bucketVaultMeta).Get([]byte(key)); data != nil {
+			return json.Unmarshal(data, &info)
+		}
+		return nil
+	})
+	return info, err
+}
+
+func (db *DB) ListSecretInfo() (map[string]SecretInfo, error) {
+	infos := make(map[string]SecretInfo)
+	err := db.conn.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketVaultMeta)
+		return b.ForEach(func(k, v []byte) error {
+			var info SecretInfo
+			if err := json.Unmarshal(v, &info); err != nil {
+				return err
+			}
+			infos[string(k)] = info
+			return nil
+		})
+	})
+	return infos, err
+}
+
+// GetVaultConfig returns the vault's key derivation settings, or nil if no
+// master passphrase has been set.
+func (db *DB) GetVaultConfig() (*VaultConfig, error) {
+	var cfg *VaultConfig
+	err := db.conn.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketVaultConfig).Get(vaultConfigKey)
+		if data == nil {
+			return nil
+		}
+		cfg = &VaultConfig{}
+		return json.Unmarshal(data, cfg)
+	})
+	return cfg, err
+}
+
+// UpdateSecrets rewrites every secret through update, which returns the new
+// value or nil to leave it as it is, and saves cfg if it is not nil, all in
+// one transaction.
+func (db *DB) UpdateSecrets(cfg *VaultConfig, update func(key string, value []byte) ([]byte, error)) (int, error) {
+	updated := 0
+	err := db.conn.Update(func(tx *bbolt.Tx) error {
+		if cfg != nil {
+			data, err := json.Marshal(cfg)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketVaultConfig).Put(vaultConfigKey, data); err != nil {
+				return err
+			}
+		}
+		b := tx.Bucket(bucketVault)
+		changes := map[string][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			value, err := update(string(k), v)
+			if value != nil {
+				changes[string(k)] = value
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range changes {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		updated = len(changes)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
 // Skill Operations
 func (db *DB) SaveSkill(name, docs string) error {
 	return db.conn.Update(func(tx *bbolt.Tx) error {
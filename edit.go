@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	editSearchMarker  = "<<<<<<< SEARCH"
+	editDivider       = "======="
+	editReplaceMarker = ">>>>>>> REPLACE"
+)
+
+// applyEdits applies edits to content and returns the result with the number
+// of changes made. Edits are either search/replace blocks:
+//
+//	<<<<<<< SEARCH
+//	exact text from the file
+//	=======
+//	replacement text
+//	>>>>>>> REPLACE
+//
+// or a unified diff. Each search text, or the old side of each hunk, has to
+// occur exactly once; otherwise nothing is changed and the error says which
+// edit failed and why.
+func applyEdits(content, edits string) (string, int, error) {
+	edits = strings.ReplaceAll(edits, "\r\n", "\n")
+	if strings.Contains(edits, editSearchMarker) {
+		return applySearchReplace(content, edits)
+	}
+	if hunkHeaderRe.MatchString(edits) {
+		return applyUnifiedDiff(content, edits)
+	}
+	return "", 0, fmt.Errorf("no edits found: use search/replace blocks (%s ... %s ... %s) or a unified diff with @@ hunk headers", editSearchMarker, editDivider, editReplaceMarker)
+}
+
+type searchReplace struct {
+	search, replace string
+}
+
+func parseSearchReplace(edits string) ([]searchReplace, error) {
+	var blocks []searchReplace
+	lines := strings.Split(edits, "\n")
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != editSearchMarker {
+			continue
+		}
+		n := len(blocks) + 1
+		divider, end := -1, -1
+		for j := i + 1; j < len(lines); j++ {
+			line := strings.TrimSpace(lines[j])
+			if line == editDivider && divider < 0 {
+				divider = j
+			} else if line == editReplaceMarker {
+				end = j
+				break
+			}
+		}
+		if divider < 0 || end < 0 {
+			return nil, fmt.Errorf("edit %d is incomplete: every %s needs a %s line and a closing %s", n, editSearchMarker, editDivider, editReplaceMarker)
+		}
+		blocks = append(blocks, searchReplace{
+			search:  strings.Join(lines[i+1:divider], "\n"),
+			replace: strings.Join(lines[divider+1:end], "\n"),
+		})
+		i = end
+	}
+	return blocks, nil
+}
+
+func applySearchReplace(content, edits string) (string, int, error) {
+	blocks, err := parseSearchReplace(edits)
+	if err != nil {
+		return "", 0, err
+	}
+	for i, b := range blocks {
+		if b.search == "" {
+			return "", 0, fmt.Errorf("edit %d has an empty search text; include the lines to replace, or the lines next to where the new text goes", i+1)
+		}
+		switch n := strings.Count(content, b.search); {
+		case n == 0:
+			return "", 0, fmt.Errorf("edit %d: search text not found (starting %q); it must match the file exactly, including indentation and whitespace", i+1, firstLine(b.search))
+		case n > 1:
+			return "", 0, fmt.Errorf("edit %d: search text is ambiguous, it occurs %d times (starting %q); include more surrounding lines so it matches once", i+1, n, firstLine(b.search))
+		}
+		content = strings.Replace(content, b.search, b.replace, 1)
+	}
+	return content, len(blocks), nil
+}
+
+var hunkHeaderRe = regexp.MustCompile(`(?m)^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+
+type diffHunk struct {
+	header   string
+	oldStart int // 1-based, as written in the header
+	old, new []string
+}
+
+func parseUnifiedDiff(edits string) []diffHunk {
+	var hunks []diffHunk
+	var h *diffHunk
+	lines := strings.Split(strings.TrimRight(edits, "\n"), "\n")
+	for i, line := range lines {
+		if m := hunkHeaderRe.FindStringSubmatch(line); m != nil {
+			start, _ := strconv.Atoi(m[1])
+			hunks = append(hunks, diffHunk{header: m[0], oldStart: start})
+			h = &hunks[len(hunks)-1]
+			continue
+		}
+		fileHeader := strings.HasPrefix(line, "diff ") ||
+			strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ")
+		if h == nil || fileHeader {
+			// File headers, before the first hunk or between the files of
+			// a multi-file diff.
+			h = nil
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, `\`):
+		case strings.HasPrefix(line, "-"):
+			h.old = append(h.old, line[1:])
+		case strings.HasPrefix(line, "+"):
+			h.new = append(h.new, line[1:])
+		default:
+			// Context; blank context lines often lose their leading space.
+			line = strings.TrimPrefix(line, " ")
+			h.old = append(h.old, line)
+			h.new = append(h.new, line)
+		}
+	}
+	return hunks
+}
+
+// applyUnifiedDiff applies the hunks of a diff in order. Line numbers in hunk
+// headers are only used to choose between several places where a hunk's old
+// lines match, since models rarely get them right.
+func applyUnifiedDiff(content, edits string) (string, int, error) {
+	hunks := parseUnifiedDiff(edits)
+	lines := strings.Split(content, "\n")
+	cursor, offset := 0, 0
+	for i, h := range hunks {
+		at := -1
+		if len(h.old) == 0 {
+			at = min(max(h.oldStart+offset, cursor), len(lines))
+		} else {
+			var matches []int
+			for j := cursor; j+len(h.old) <= len(lines); j++ {
+				if equalLines(lines[j:j+len(h.old)], h.old) {
+					matches = append(matches, j)
+				}
+			}
+			switch {
+			case len(matches) == 0:
+				return "", 0, fmt.Errorf("hunk %d (%s) does not match the file: its context and removed lines (starting %q) must match exactly, including whitespace", i+1, h.header, h.old[0])
+			case len(matches) == 1:
+				at = matches[0]
+			default:
+				for _, j := range matches {
+					if j == h.oldStart-1+offset {
+						at = j
+					}
+				}
+				if at < 0 {
+					return "", 0, fmt.Errorf("hunk %d (%s) is ambiguous: its lines occur %d times and none at the line in its header; add more context lines", i+1, h.header, len(matches))
+				}
+			}
+		}
+		lines = append(lines[:at], append(append([]string{}, h.new...), lines[at+len(h.old):]...)...)
+		cursor = at + len(h.new)
+		offset += len(h.new) - len(h.old)
+	}
+	return strings.Join(lines, "\n"), len(hunks), nil
+}
+
+func equalLines(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimLeft(s, "\n"), "\n")
+	return line
+}
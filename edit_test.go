@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func searchReplaceBlock(search, replace string) string {
+	return fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n", editSearchMarker, search, editDivider, replace, editReplaceMarker)
+}
+
+func TestApplyEditsSearchReplace(t *testing.T) {
+	const content = "func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 1\n}\n"
+	tests := []struct {
+		name    string
+		edits   string
+		want    string
+		changes int
+		err     string
+	}{
+		{
+			name:    "single",
+			edits:   searchReplaceBlock("func a() {\n\treturn 1", "func a() {\n\treturn 2"),
+			want:    "func a() {\n\treturn 2\n}\n\nfunc b() {\n\treturn 1\n}\n",
+			changes: 1,
+		},
+		{
+			name:    "several in order",
+			edits:   searchReplaceBlock("func a()", "func x()") + searchReplaceBlock("func b()", "func y()"),
+			want:    "func x() {\n\treturn 1\n}\n\nfunc y() {\n\treturn 1\n}\n",
+			changes: 2,
+		},
+		{
+			name:    "crlf edits",
+			edits:   strings.ReplaceAll(searchReplaceBlock("func b()", "func y()"), "\n", "\r\n"),
+			want:    "func a() {\n\treturn 1\n}\n\nfunc y() {\n\treturn 1\n}\n",
+			changes: 1,
+		},
+		{
+			name:  "ambiguous",
+			edits: searchReplaceBlock("\treturn 1", "\treturn 2"),
+			err:   "edit 1: search text is ambiguous, it occurs 2 times",
+		},
+		{
+			name:  "no match",
+			edits: searchReplaceBlock("func c() {", "func d() {"),
+			err:   "edit 1: search text not found",
+		},
+		{
+			name:  "indentation must match",
+			edits: searchReplaceBlock("    return 1\n}\n\nfunc b", "x"),
+			err:   "edit 1: search text not found",
+		},
+		{
+			name:  "second edit fails",
+			edits: searchReplaceBlock("func a()", "func x()") + searchReplaceBlock("func a()", "func z()"),
+			err:   "edit 2: search text not found",
+		},
+		{
+			name:  "empty search",
+			edits: searchReplaceBlock("", "x"),
+			err:   "edit 1 has an empty search text",
+		},
+		{
+			name:  "incomplete",
+			edits: editSearchMarker + "\nfunc a()\n" + editDivider + "\nfunc x()\n",
+			err:   "edit 1 is incomplete",
+		},
+		{
+			name:  "no edits",
+			edits: "replace return 1 with return 2",
+			err:   "no edits found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changes, err := applyEdits(content, tt.edits)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || changes != tt.changes {
+				t.Errorf("got %q (%d changes), want %q (%d changes)", got, changes, tt.want, tt.changes)
+			}
+		})
+	}
+}
+
+func TestApplyEditsUnifiedDiff(t *testing.T) {
+	const content = "a\nb\nc\nx\nd\ne\nf\nx\ng\n"
+	tests := []struct {
+		name  string
+		edits string
+		want  string
+		err   string
+	}{
+		{
+			name:  "wrong line numbers",
+			edits: "@@ -40,3 +40,3 @@\n a\n-b\n+B\n c\n",
+			want:  "a\nB\nc\nx\nd\ne\nf\nx\ng\n",
+		},
+		{
+			name:  "header breaks a tie",
+			edits: "@@ -8 +8 @@\n-x\n+X\n",
+			want:  "a\nb\nc\nx\nd\ne\nf\nX\ng\n",
+		},
+		{
+			name:  "ambiguous",
+			edits: "@@ -20 +20 @@\n-x\n+X\n",
+			err:   "hunk 1 (@@ -20 +20 @@) is ambiguous",
+		},
+		{
+			name:  "no match",
+			edits: "@@ -1,2 +1,2 @@\n a\n-z\n+Z\n",
+			err:   "hunk 1 (@@ -1,2 +1,2 @@) does not match the file",
+		},
+		{
+			name:  "pure insertion",
+			edits: "@@ -0,0 +1 @@\n+top\n",
+			want:  "top\na\nb\nc\nx\nd\ne\nf\nx\ng\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := applyEdits(content, tt.edits)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestUnifiedDiffRoundTrip applies the diffs unifiedDiff shows back to the
+// original content.
+func TestUnifiedDiffRoundTrip(t *testing.T) {
+	long := func(change func(i int) string) string {
+		var b strings.Builder
+		for i := range 40 {
+			b.WriteString(change(i) + "\n")
+		}
+		return b.String()
+	}
+	tests := []struct {
+		name     string
+		old, new string
+	}{
+		{"change", "a\nb\nc\n", "a\nB\nc\n"},
+		{"insert", "a\nc\n", "a\nb\nc\n"},
+		{"delete", "a\nb\nc\n", "a\nc\n"},
+		{"into empty", "", "a\nb\n"},
+		{"to empty", "a\nb\n", ""},
+		{"no final newline", "a\nb", "a\nB"},
+		{"repeated lines", "x\nx\nx\ny\nx\nx\n", "x\nx\nX\ny\nx\nx\n"},
+		{
+			name: "separate hunks",
+			old:  long(func(i int) string { return fmt.Sprint("line ", i) }),
+			new: long(func(i int) string {
+				if i == 2 || i == 20 || i == 37 {
+					return fmt.Sprint("changed ", i)
+				}
+				return fmt.Sprint("line ", i)
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := unifiedDiff("f.txt", tt.old, tt.new)
+			got, _, err := applyEdits(tt.old, diff)
+			if err != nil {
+				t.Fatalf("applying\n%s\nfailed: %v", diff, err)
+			}
+			if got != tt.new {
+				t.Errorf("applying\n%s\ngave %q, want %q", diff, got, tt.new)
+			}
+		})
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	diff := unifiedDiff("f.txt", "a\nb\nc\n", "a\nB\nc\nd\n")
+	if added, removed := diffStat(diff); added != 2 || removed != 1 {
+		t.Errorf("diffStat = +%d -%d, want +2 -1\n%s", added, removed, diff)
+	}
+}
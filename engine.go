@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"html"
+	"net/http"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,18 +18,40 @@ import (
 type EventType string
 
 const (
-	EventThinking     EventType = "thinking"
-	EventExecuting    EventType = "executing"
-	EventFileOp       EventType = "file_op"
-	EventOutput       EventType = "output"
-	EventResponse     EventType = "response"
-	EventError        EventType = "error"
-	EventUserMessage  EventType = "user_message"
+	EventThinking      EventType = "thinking"
+	EventExecuting     EventType = "executing"
+	EventFileOp        EventType = "file_op"
+	EventOutput        EventType = "output"
+	EventResponse      EventType = "response"
+	EventResponseDelta EventType = "response_delta"
+	EventError         EventType = "error"
+	EventUserMessage   EventType = "user_message"
+	EventDone          EventType = "done" // the turn is over, successfully or not
+
+	// A running command reports each line of output as a command_output
+	// event, then a command_done event with its exit code and duration.
+	EventCommandOutput EventType = "command_output"
+	EventCommandDone   EventType = "command_done"
+	EventJob           EventType = "job" // a background job started or finished
+
+	EventApproval         EventType = "approval_request"
+	EventApprovalResolved EventType = "approval_resolved"
+
+	// EventVaultDenied reports a use of a secret its access policy refused;
+	// ID names the secret.
+	EventVaultDenied EventType = "vault_denied"
 )
 
 type Event struct {
 	Type    EventType `json:"type"`
 	Content string    `json:"content"`
+	ID      string    `json:"id,omitempty"`
+	Rule    string    `json:"rule,omitempty"` // policy rule that let a command run
+	Kind    string    `json:"kind,omitempty"` // of an approval_request, see PendingApproval
+
+	Stream   string  `json:"stream,omitempty"`    // "stdout" or "stderr" for command_output
+	ExitCode *int    `json:"exit_code,omitempty"` // set on command_done
+	Duration float64 `json:"duration,omitempty"`  // seconds, set on command_done
 }
 
 type Engine struct {
@@ -32,9 +60,30 @@ type Engine struct {
 	System      string
 	History     []Message
 	SessionID   string
+	Sandbox     bool   // run this session's commands in the sandbox
+	Shell       *Shell // the session's persistent shell
 	Subscribers []chan Event
 	DB          *DB
+	Vault       *Vault
+	Policy      *Policy
 	mu          sync.Mutex
+
+	// tagOnlyModels records models that rejected native tool definitions.
+	tagOnlyModels map[string]bool
+
+	approvals      map[string]*PendingApproval
+	alwaysApproved map[string]bool
+	nextApprovalID int
+
+	jobs      map[string]*Job
+	nextJobID int
+
+	// turn is the index in History of the user message that started the
+	// current turn; file changes are checkpointed under it.
+	turn int
+
+	// cancelTurn stops the turn in progress, if any.
+	cancelTurn context.CancelFunc
 }
 
 func NewEngine(cfg Config, baseSystem string, sessionID string, history []Message, db *DB) *Engine {
@@ -43,7 +92,16 @@ func NewEngine(cfg Config, baseSystem string, sessionID string, history []Messag
 		BaseSystem: baseSystem,
 		SessionID:  sessionID,
 		DB:         db,
+		Vault:      newVault(db, cfg.VaultTimeout),
 		History:    history,
+		Policy:     &Policy{},
+		Sandbox:    cfg.Sandbox && sandboxAvailable,
+		Shell:      newShell(workspaceRoot()),
+
+		tagOnlyModels:  map[string]bool{},
+		approvals:      map[string]*PendingApproval{},
+		alwaysApproved: map[string]bool{},
+		jobs:           map[string]*Job{},
 	}
 	e.RefreshSystemPrompt()
 	return e
@@ -58,7 +116,9 @@ func (e *Engine) RefreshSystemPrompt() {
 func (e *Engine) Subscribe() chan Event {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	ch := make(chan Event, 10)
+	// Streamed responses arrive as many small events, so leave room for a
+	// burst without dropping deltas.
+	ch := make(chan Event, 256)
 	e.Subscribers = append(e.Subscribers, ch)
 	return ch
 }
@@ -74,12 +134,100 @@ func (e *Engine) UpdateConfig(key, value string) {
 		e.Config.Model = value
 	case "SHREW_CUSTOM_INSTRUCTIONS":
 		e.Config.CustomInstructions = value
+	case "SHREW_NATIVE_TOOLS":
+		e.Config.NativeTools = value != "false"
+	case "SHREW_STOP_ON_ERROR":
+		e.Config.StopOnError = value == "true"
+	case "SHREW_COMMAND_TIMEOUT":
+		e.Config.CommandTimeout = parseSeconds(value, defaultCommandTimeout)
+	case "SHREW_MAX_OUTPUT":
+		e.Config.MaxOutput = parseBytes(value, defaultMaxOutput)
 	}
 	e.mu.Unlock()
 	e.RefreshSystemPrompt()
 }
 
+// checkPolicy evaluates a command against the command policy. Commands no
+// rule covers need approval when approvals are on and run otherwise.
+func (e *Engine) checkPolicy(command string) PolicyDecision {
+	e.mu.Lock()
+	policy := e.Policy
+	fallback := PolicyAllow
+	if e.Config.RequireApproval {
+		fallback = PolicyAsk
+	}
+	e.mu.Unlock()
+	return policy.Evaluate(command, fallback)
+}
+
+// checkPath resolves a path named in a file action, relative to the shell's
+// working directory, and checks it against the policy's file rules. Shrew's
+// own database, .env and policy files are always out of reach.
+func (e *Engine) checkPath(path string, isDir bool) (string, error) {
+	e.mu.Lock()
+	files := e.Policy.Files
+	cwd, root := e.Shell.Dir(), e.Shell.Root
+	names := []string{e.Config.PolicyFile, "shrew.db", ".env"}
+	e.mu.Unlock()
+	var protected []string
+	for _, p := range names {
+		switch {
+		case p == "":
+		case filepath.IsAbs(p):
+			protected = append(protected, filepath.Clean(p))
+		default:
+			protected = append(protected, filepath.Join(root, p))
+		}
+	}
+	return files.checkPath(path, cwd, isDir, protected)
+}
+
+// canAccess reports whether the file rules allow path.
+func (e *Engine) canAccess(path string, isDir bool) bool {
+	_, err := e.checkPath(path, isDir)
+	return err == nil
+}
+
+// SetSandbox turns the sandbox on or off for the current session.
+func (e *Engine) SetSandbox(enabled bool) error {
+	if enabled && !sandboxAvailable {
+		return fmt.Errorf("the sandbox is only available on Linux")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Sandbox = enabled
+	e.saveSession()
+	return nil
+}
+
+// ResetShell replaces the session's shell with a fresh one in the workspace
+// root, dropping its working directory, variables and functions.
+func (e *Engine) ResetShell() *Shell {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Shell = newShell(e.Shell.Root)
+	return e.Shell
+}
+
+// SavePolicy validates a policy document, writes it to the policy file and
+// puts it into effect.
+func (e *Engine) SavePolicy(data []byte) error {
+	policy, err := parsePolicy(data)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := policy.save(e.Config.PolicyFile); err != nil {
+		return err
+	}
+	e.Policy = policy
+	return nil
+}
+
+// broadcast sends an event to every subscriber, with vault values redacted.
 func (e *Engine) broadcast(event Event) {
+	event.Content = e.Vault.Redact(event.Content)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	for _, sub := range e.Subscribers {
@@ -91,165 +239,438 @@ func (e *Engine) broadcast(event Event) {
 }
 
 func (e *Engine) Process(input string) {
+	ctx, cancel := context.WithCancel(context.Background())
 	e.mu.Lock()
+	e.turn = len(e.History)
+	turn := e.turn
 	e.History = append(e.History, Message{Role: "user", Content: input})
+	e.cancelTurn = cancel
 	e.mu.Unlock()
-	e.broadcast(Event{Type: EventUserMessage, Content: input})
-	e.runLoop()
+	defer func() {
+		e.mu.Lock()
+		e.cancelTurn = nil
+		e.mu.Unlock()
+		cancel()
+		e.broadcast(Event{Type: EventDone})
+	}()
+
+	e.broadcast(Event{Type: EventUserMessage, Content: input, ID: strconv.Itoa(turn)})
+	e.runLoop(ctx)
 }
 
-func (e *Engine) runLoop() {
+// Cancel stops the turn in progress: the model request is abandoned, a
+// running command is killed along with its process group and pending
+// approvals are rejected. It reports whether there was anything to cancel.
+func (e *Engine) Cancel() bool {
+	e.mu.Lock()
+	cancel := e.cancelTurn
+	e.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	return true
+}
+
+func (e *Engine) runLoop(ctx context.Context) {
+	defer e.forgetReveals()
 	for {
+		if ctx.Err() != nil {
+			e.broadcast(Event{Type: EventError, Content: "Cancelled."})
+			return
+		}
 		e.broadcast(Event{Type: EventThinking, Content: ""})
-		resp, err := callAPI(e.Config, e.System, e.History)
+		msg, err := e.complete(ctx)
+		if ctx.Err() != nil {
+			e.broadcast(Event{Type: EventError, Content: "Cancelled."})
+			return
+		}
 		if err != nil {
 			e.broadcast(Event{Type: EventError, Content: err.Error()})
 			return
 		}
+		resp := msg.Content
+		// A secret the model repeats from a vault_get is not saved either.
+		saved := Message{Role: "assistant", Content: e.Vault.Redact(resp), ToolCalls: e.redactCalls(msg.ToolCalls)}
 
 		e.mu.Lock()
-		e.History = append(e.History, Message{Role: "assistant", Content: resp})
-		e.DB.SaveSession(Session{ID: e.SessionID, Messages: e.History, Timestamp: time.Now().Format(time.RFC3339)})
+		e.History = append(e.History, saved)
+		e.saveSession()
+		stopOnError := e.Config.StopOnError
 		e.mu.Unlock()
-		e.broadcast(Event{Type: EventResponse, Content: resp})
+		if resp != "" || len(msg.ToolCalls) == 0 {
+			e.broadcast(Event{Type: EventResponse, Content: resp})
+		}
+
+		// Native tool calls take precedence over tags in the text. Every
+		// call needs an answer, so skipped ones are reported as such.
+		if len(msg.ToolCalls) > 0 {
+			failed := false
+			for _, call := range msg.ToolCalls {
+				if ctx.Err() != nil {
+					e.addToolResult(call, ToolResult{Output: "Skipped: cancelled by the user.", Display: "Skipped " + call.label()})
+					continue
+				}
+				if failed && stopOnError {
+					e.addToolResult(call, ToolResult{Output: "Skipped: a previous action failed.", Display: "Skipped " + call.label()})
+					continue
+				}
+				result := e.executeTool(ctx, call)
+				failed = failed || result.Failed
+				e.addToolResult(call, result)
+			}
+			continue
+		}
 
 		// Multi-tag extraction
-		handled := e.handleTags(resp)
+		handled := e.handleTags(ctx, resp)
 		if !handled {
 			break
 		}
 	}
 }
 
-func (e *Engine) handleTags(content string) bool {
-	// 1. Check for <run>
-	runRe := regexp.MustCompile(`(?s)<run>(.*?)</run>`)
-	if match := runRe.FindStringSubmatch(content); len(match) >= 2 {
-		cmdStr := strings.TrimSpace(match[1])
-		
-		// Broadcast the command WITH placeholders to keep secrets hidden from user/logs
-		e.broadcast(Event{Type: EventExecuting, Content: cmdStr})
+// complete sends the current conversation to the configured provider,
+// broadcasting the reply as it streams in when the provider supports it.
+// Native tool definitions are offered when the provider supports them;
+// otherwise the model relies on the tag protocol from the system prompt.
+func (e *Engine) complete(ctx context.Context) (Message, error) {
+	e.mu.Lock()
+	cfg := e.Config
+	req := CompletionRequest{System: e.System + "\n\n" + e.Shell.describe(), Messages: modelHistory(e.History)}
+	useTools := cfg.NativeTools && !e.tagOnlyModels[cfg.Model]
+	e.mu.Unlock()
 
-		// Resolve placeholders for actual execution
-		resolvedCmd, err := e.resolveVaultPlaceholders(cmdStr)
-		if err != nil {
-			e.addOutput(err.Error(), "Secret resolution failed.")
-			return true
+	provider, model, err := newProvider(cfg)
+	if err != nil {
+		return Message{}, err
+	}
+	req.Model = model
+	caps := provider.Capabilities()
+	if useTools && caps.ToolCalling {
+		req.Tools = engineTools
+	} else {
+		req.Messages = flattenToolMessages(req.Messages)
+	}
+
+	call := func(req CompletionRequest) (Message, error) {
+		if !caps.Streaming {
+			return provider.Complete(ctx, req)
 		}
+		return provider.Stream(ctx, req, func(delta string) {
+			e.broadcast(Event{Type: EventResponseDelta, Content: delta})
+		})
+	}
 
-		output, _ := executeCommand(resolvedCmd)
-		e.addOutput(fmt.Sprintf("<output>\n%s\n</output>", output), output)
-		return true
+	msg, err := call(req)
+	// Models that do not support tools reject the request outright. Remember
+	// that and fall back to the tag protocol for the rest of the run.
+	if err != nil && req.Tools != nil && rejectsTools(err) {
+		e.mu.Lock()
+		e.tagOnlyModels[cfg.Model] = true
+		e.mu.Unlock()
+		req.Tools = nil
+		req.Messages = flattenToolMessages(req.Messages)
+		msg, err = call(req)
 	}
+	return msg, err
+}
 
-	// 2. Check for <read>
-	readRe := regexp.MustCompile(`(?s)<read>(.*?)</read>`)
-	if match := readRe.FindStringSubmatch(content); len(match) >= 2 {
-		path := strings.TrimSpace(match[1])
-		e.broadcast(Event{Type: EventFileOp, Content: "Reading " + path})
-		data, err := os.ReadFile(path)
-		output := string(data)
-		if err != nil {
-			output = fmt.Sprintf("Error reading file: %v", err)
-		}
-		e.addOutput(fmt.Sprintf("<output>\n%s\n</output>", output), output)
-		return true
+// rejectsTools reports whether err is a provider refusing a request because
+// of its tools. Other bad requests, such as an oversized context, are left to
+// the caller rather than switching the model to tags for good.
+func rejectsTools(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		return false
 	}
+	body := strings.ToLower(apiErr.Body)
+	return strings.Contains(body, "tool") || strings.Contains(body, "function")
+}
 
-	// 3. Check for <write>
-	writeRe := regexp.MustCompile(`(?s)<write>(.*?)</write>(.*?)[\n\r]*</write>`)
-	if match := writeRe.FindStringSubmatch(content); len(match) >= 3 {
-		path := strings.TrimSpace(match[1])
-		body := match[2]
-		e.broadcast(Event{Type: EventFileOp, Content: "Writing " + path})
-		err := os.WriteFile(path, []byte(body), 0644)
-		output := "File written successfully"
-		if err != nil {
-			output = fmt.Sprintf("Error writing file: %v", err)
-		}
-		e.addOutput(fmt.Sprintf("<output>\n%s\n</output>", output), output)
-		return true
+// handleTags executes every action tag in a tag-protocol response, in the
+// order they appear, and returns their combined output to the model as one
+// message. With StopOnError set, the actions after a failed one are skipped.
+func (e *Engine) handleTags(ctx context.Context, content string) bool {
+	calls := parseTags(content)
+	if len(calls) == 0 {
+		return false
 	}
 
-	// 4. Check for <vault_get>
-	vaultRe := regexp.MustCompile(`<vault_get\s+key="(.*?)"\s*/>`)
-	if match := vaultRe.FindStringSubmatch(content); len(match) >= 2 {
-		key := match[1]
-		val, err := e.DB.GetSecret(key)
-		output := val
-		if err != nil {
-			output = fmt.Sprintf("Error: Secret '%s' not found in vault.", key)
-		}
-		e.addOutput(fmt.Sprintf("<vault_output key=\"%s\">\n%s\n</vault_output>", key, output), "Retrieved secret from vault: "+key)
+	if len(calls) == 1 {
+		result := e.executeTool(ctx, calls[0])
+		e.addOutput(result)
 		return true
 	}
 
-	// 4.2 Check for <vault_list>
-	vaultListRe := regexp.MustCompile(`<vault_list\s*/>`)
-	if vaultListRe.MatchString(content) {
-		secrets, err := e.DB.ListSecrets()
-		var keys []string
-		for k := range secrets {
-			keys = append(keys, k)
+	e.mu.Lock()
+	stopOnError := e.Config.StopOnError
+	e.mu.Unlock()
+
+	// revealed is the combined output as the model gets it this turn, with
+	// the values vault_get revealed.
+	var combined, revealed []string
+	add := func(text, reveal string) {
+		combined = append(combined, text)
+		if reveal == "" {
+			reveal = text
+		}
+		revealed = append(revealed, reveal)
+	}
+	failed := false
+	for i, call := range calls {
+		heading := fmt.Sprintf("[action %d/%d] %s", i+1, len(calls), call.label())
+		if ctx.Err() != nil {
+			add(heading+"\nSkipped: cancelled by the user.", "")
+			continue
+		}
+		if failed && stopOnError {
+			add(heading+"\nSkipped: a previous action failed.", "")
+			continue
 		}
-		output := "Available vault keys: " + strings.Join(keys, ", ")
-		if err != nil || len(keys) == 0 {
-			output = "No keys found in vault."
+		result := e.executeTool(ctx, call)
+		e.broadcastOutput(result.Display)
+		if result.Failed {
+			failed = true
+			heading += " (failed)"
 		}
-		e.addOutput(fmt.Sprintf("<vault_keys>\n%s\n</vault_keys>", output), "Listed vault keys")
-		return true
+		reveal := ""
+		if result.reveal != "" {
+			reveal = heading + "\n" + result.reveal
+		}
+		add(heading+"\n"+result.Output, reveal)
+	}
+	msg := Message{Role: "user", Content: strings.Join(combined, "\n\n")}
+	if reveal := strings.Join(revealed, "\n\n"); reveal != msg.Content {
+		msg.reveal = reveal
 	}
+	e.appendHistory(msg)
+	return true
+}
 
-	// 5. Check for <save_skill>
-	skillSaveRe := regexp.MustCompile(`(?s)<save_skill\s+name="(.*?)">(.*?)</save_skill>`)
-	if match := skillSaveRe.FindStringSubmatch(content); len(match) >= 3 {
-		name := match[1]
-		docs := match[2]
-		e.DB.SaveSkill(name, docs)
-		e.RefreshSystemPrompt()
-		e.addOutput(fmt.Sprintf("Skill '%s' saved successfully.", name), "Learned new skill: "+name)
-		return true
+// tagPattern maps one XML action tag to the tool call it stands for.
+type tagPattern struct {
+	re    *regexp.Regexp
+	build func(m []string) ToolCall
+}
+
+var tagPatterns = []tagPattern{
+	{regexp.MustCompile(`(?s)<run((?:\s+[\w-]+="[^"]*")*)\s*>(.*?)</run>`), func(m []string) ToolCall {
+		args := parseAttrs(m[1])
+		args["command"] = m[2]
+		return ToolCall{Name: "run_command", Arguments: args}
+	}},
+	{regexp.MustCompile(`(?s)<job_start>(.*?)</job_start>`), func(m []string) ToolCall {
+		return ToolCall{Name: "job_start", Arguments: map[string]string{"command": m[1]}}
+	}},
+	{regexp.MustCompile(`<job_output\s+id="(.*?)"\s*/>`), func(m []string) ToolCall {
+		return ToolCall{Name: "job_output", Arguments: map[string]string{"id": m[1]}}
+	}},
+	{regexp.MustCompile(`<job_status((?:\s+[\w-]+="[^"]*")*)\s*/>`), func(m []string) ToolCall {
+		return ToolCall{Name: "job_status", Arguments: parseAttrs(m[1])}
+	}},
+	{regexp.MustCompile(`<job_kill\s+id="(.*?)"\s*/>`), func(m []string) ToolCall {
+		return ToolCall{Name: "job_kill", Arguments: map[string]string{"id": m[1]}}
+	}},
+	{regexp.MustCompile(`(?s)<read((?:\s+[\w-]+="[^"]*")*)\s*>(.*?)</read>`), func(m []string) ToolCall {
+		args := parseAttrs(m[1])
+		args["path"] = m[2]
+		args["start_line"], args["end_line"] = args["start"], args["end"]
+		return ToolCall{Name: "read_file", Arguments: args}
+	}},
+	{regexp.MustCompile(`(?s)<write>(.*?)</write>(.*?)[\n\r]*</write>`), func(m []string) ToolCall {
+		return ToolCall{Name: "write_file", Arguments: map[string]string{"path": m[1], "content": m[2]}}
+	}},
+	{regexp.MustCompile(`(?s)<edit\s+path="(.*?)"\s*>\n?(.*?)\n?</edit>`), func(m []string) ToolCall {
+		return ToolCall{Name: "edit_file", Arguments: map[string]string{"path": m[1], "edits": m[2]}}
+	}},
+	{regexp.MustCompile(`(?s)<search((?:\s+[\w-]+="[^"]*")*)\s*>(.*?)</search>`), func(m []string) ToolCall {
+		args := parseAttrs(m[1])
+		args["pattern"] = m[2]
+		if v, ok := args["max"]; ok {
+			args["max_results"] = v
+		}
+		return ToolCall{Name: "search_files", Arguments: args}
+	}},
+	{regexp.MustCompile(`<vault_get\s+key="(.*?)"\s*/>`), func(m []string) ToolCall {
+		return ToolCall{Name: "vault_get", Arguments: map[string]string{"key": m[1]}}
+	}},
+	{regexp.MustCompile(`<vault_list\s*/>`), func(m []string) ToolCall {
+		return ToolCall{Name: "vault_list"}
+	}},
+	{regexp.MustCompile(`(?s)<save_skill\s+name="(.*?)">(.*?)</save_skill>`), func(m []string) ToolCall {
+		return ToolCall{Name: "save_skill", Arguments: map[string]string{"name": m[1], "docs": m[2]}}
+	}},
+	{regexp.MustCompile(`<get_skill\s+name="(.*?)"\s*/>`), func(m []string) ToolCall {
+		return ToolCall{Name: "get_skill", Arguments: map[string]string{"name": m[1]}}
+	}},
+}
+
+var attrRe = regexp.MustCompile(`([\w-]+)="([^"]*)"`)
+
+// parseAttrs reads name="value" attributes from the inside of a tag.
+func parseAttrs(s string) map[string]string {
+	attrs := map[string]string{}
+	for _, m := range attrRe.FindAllStringSubmatch(s, -1) {
+		attrs[m[1]] = html.UnescapeString(m[2])
 	}
+	return attrs
+}
 
-	// 6. Check for <get_skill>
-	skillGetRe := regexp.MustCompile(`<get_skill\s+name="(.*?)"\s*/>`)
-	if match := skillGetRe.FindStringSubmatch(content); len(match) >= 2 {
-		name := match[1]
-		docs, err := e.DB.GetSkill(name)
-		output := docs
-		if err != nil {
-			output = fmt.Sprintf("Error: Skill '%s' not found.", name)
+// parseTags returns the action tags in content as tool calls, in document
+// order. A tag nested inside another one (a <run> in the body of a <write>)
+// is part of the outer tag's content and is not an action of its own.
+func parseTags(content string) []ToolCall {
+	type found struct {
+		start, end int
+		call       ToolCall
+	}
+	var matches []found
+	for _, p := range tagPatterns {
+		for _, loc := range p.re.FindAllStringSubmatchIndex(content, -1) {
+			m := make([]string, len(loc)/2)
+			for i := range m {
+				if loc[2*i] >= 0 {
+					m[i] = content[loc[2*i]:loc[2*i+1]]
+				}
+			}
+			matches = append(matches, found{start: loc[0], end: loc[1], call: p.build(m)})
 		}
-		e.addOutput(fmt.Sprintf("<skill_output name=\"%s\">\n%s\n</skill_output>", name, output), "Retrieved skill docs: "+name)
-		return true
 	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
 
-	return false
+	var calls []ToolCall
+	end := 0
+	for _, m := range matches {
+		if m.start < end {
+			continue
+		}
+		calls = append(calls, m.call)
+		end = m.end
+	}
+	return calls
 }
 
-func (e *Engine) resolveVaultPlaceholders(cmdStr string) (string, error) {
-	resolvedCmd := cmdStr
-	vaultPlaceholderRe := regexp.MustCompile(`\[\[vault:(.*?)\]\]`)
-	placeholders := vaultPlaceholderRe.FindAllStringSubmatch(cmdStr, -1)
+// resolveVaultPlaceholders rewrites the [[vault:NAME]] placeholders of a
+// command into variable references and returns the variables, holding the
+// secret values, to add to the command's environment. The values never
+// become part of the command line, where quotes or $ in them would be
+// interpreted and ps would show them. Each secret's access policy has to
+// allow the command.
+func (e *Engine) resolveVaultPlaceholders(cmdStr string) (string, []string, error) {
+	resolvedCmd, vars, err := bindPlaceholders(cmdStr)
+	if err != nil {
+		return "", nil, err
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	for _, ph := range placeholders {
-		if len(ph) < 2 {
-			continue
+	var env []string
+	for _, name := range names {
+		key := vars[name]
+		if err := e.checkSecretUse(key, cmdStr); err != nil {
+			return "", nil, err
 		}
-		key := ph[1]
-		val, err := e.DB.GetSecret(key)
+		val, err := e.secret(key)
 		if err != nil {
-			return "", fmt.Errorf("error: secret '%s' not found in vault", key)
+			if errors.Is(err, errVaultLocked) {
+				return "", nil, fmt.Errorf("error: secret '%s' is not available: %v", key, err)
+			}
+			return "", nil, fmt.Errorf("error: secret '%s' not found in vault", key)
+		}
+		if strings.ContainsRune(val, 0) {
+			return "", nil, fmt.Errorf("error: secret '%s' contains a NUL byte and cannot be passed to a command", key)
 		}
-		resolvedCmd = strings.ReplaceAll(resolvedCmd, ph[0], val)
+		env = append(env, name+"="+val)
 	}
-	return resolvedCmd, nil
+	return resolvedCmd, env, nil
 }
 
-func (e *Engine) addOutput(fullMsg string, display string) {
+// secret reads a secret for the current session and records the use.
+func (e *Engine) secret(name string) (string, error) {
+	val, err := e.Vault.Get(name)
+	if err != nil {
+		return "", err
+	}
 	e.mu.Lock()
-	e.History = append(e.History, Message{Role: "user", Content: fullMsg})
-	e.DB.SaveSession(Session{ID: e.SessionID, Messages: e.History, Timestamp: time.Now().Format(time.RFC3339)})
+	sessionID := e.SessionID
 	e.mu.Unlock()
-	e.broadcast(Event{Type: EventOutput, Content: display})
+	e.Vault.Use(name, sessionID)
+	return val, nil
+}
+
+// appendHistory adds a message to the conversation and persists the session.
+func (e *Engine) appendHistory(msg Message) {
+	e.mu.Lock()
+	e.History = append(e.History, msg)
+	e.saveSession()
+	e.mu.Unlock()
+}
+
+// saveSession persists the current session. The caller holds e.mu.
+func (e *Engine) saveSession() {
+	sandbox := e.Sandbox
+	e.DB.SaveSession(Session{ID: e.SessionID, Messages: e.History, Sandbox: &sandbox, Timestamp: time.Now().Format(time.RFC3339)})
+}
+
+// modelHistory returns the messages to send to the model: the history, with
+// the values revealed in this turn.
+func modelHistory(history []Message) []Message {
+	messages := make([]Message, len(history))
+	copy(messages, history)
+	for i, m := range messages {
+		if m.reveal != "" {
+			messages[i].Content = m.reveal
+		}
+	}
+	return messages
+}
+
+// forgetReveals drops the values vault_get revealed once the turn is over;
+// from then on the model sees their placeholders, like the saved session.
+func (e *Engine) forgetReveals() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range e.History {
+		e.History[i].reveal = ""
+	}
+}
+
+// redactCalls returns tool calls with vault values in their arguments
+// replaced by placeholders, for the history.
+func (e *Engine) redactCalls(calls []ToolCall) []ToolCall {
+	if calls == nil {
+		return nil
+	}
+	redacted := make([]ToolCall, len(calls))
+	for i, call := range calls {
+		args := make(map[string]string, len(call.Arguments))
+		for k, v := range call.Arguments {
+			args[k] = e.Vault.Redact(v)
+		}
+		redacted[i] = ToolCall{ID: call.ID, Name: call.Name, Arguments: args}
+	}
+	return redacted
+}
+
+// addToolResult records the answer to a native tool call.
+func (e *Engine) addToolResult(call ToolCall, result ToolResult) {
+	e.appendHistory(Message{Role: "tool", Content: result.Output, ToolCallID: call.ID, Name: call.Name, reveal: result.reveal})
+	e.broadcastOutput(result.Display)
+}
+
+func (e *Engine) addOutput(result ToolResult) {
+	e.appendHistory(Message{Role: "user", Content: result.Output, reveal: result.reveal})
+	e.broadcastOutput(result.Display)
+}
+
+// broadcastOutput shows an action's result to the user. Commands stream
+// their output as they run and leave nothing to show here.
+func (e *Engine) broadcastOutput(display string) {
+	if display != "" {
+		e.broadcast(Event{Type: EventOutput, Content: display})
+	}
 }
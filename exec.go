@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCommandTimeout = 2 * time.Minute
+	defaultMaxOutput      = 32 << 10
+
+	// maxLiveLines bounds how many lines of each stream are reported while a
+	// command runs; the final result still holds the capped output.
+	maxLiveLines = 1000
+	// maxLineLength splits lines that never end, like progress bars.
+	maxLineLength = 4096
+)
+
+// sandboxInitArg is the first argument of shrew re-executed as the init
+// process of a sandbox.
+const sandboxInitArg = "__sandbox_init"
+
+// CommandOptions bounds one shell command. A zero Timeout or MaxOutput means
+// no limit. OnLine, when set, receives each line of output as it is written,
+// with stream "stdout" or "stderr"; it may be called from two goroutines.
+//
+// With Sandbox set the command runs in Linux namespaces where only
+// SandboxDir (default the working directory) and /tmp are writable, and
+// without network access unless Network is set.
+type CommandOptions struct {
+	Timeout   time.Duration
+	MaxOutput int
+	OnLine    func(stream, line string)
+	// Env holds variables added to the command's environment alone, such
+	// as the secrets it references. Those named with secretEnvPrefix are
+	// not saved in the shell's state.
+	Env []string
+
+	// Shell, when set, is the persistent shell the command runs in. Its
+	// state is updated once the command exits.
+	Shell *Shell
+
+	Sandbox    bool
+	SandboxDir string
+	Network    bool
+}
+
+// ExecResult describes a finished command. ExitCode is -1 when the process
+// did not exit on its own, and Signal then names the signal that ended it.
+// Status says how it ended, in words.
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Signal   string
+	Duration time.Duration
+	Status   string
+}
+
+// outputXML renders the result for the model: exit code, signal and duration
+// as attributes of <output>, followed by separate stdout and stderr sections.
+// attrs holds any further attributes, such as the policy rule.
+func (r ExecResult) outputXML(attrs string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<output exit_code=\"%d\"", r.ExitCode)
+	if r.Signal != "" {
+		fmt.Fprintf(&b, " signal=\"%s\"", r.Signal)
+	}
+	fmt.Fprintf(&b, " duration=\"%s\"%s>\n", r.Duration.Round(time.Millisecond), attrs)
+	if r.Stdout != "" {
+		fmt.Fprintf(&b, "<stdout>\n%s\n</stdout>\n", r.Stdout)
+	}
+	if r.Stderr != "" {
+		fmt.Fprintf(&b, "<stderr>\n%s\n</stderr>\n", r.Stderr)
+	}
+	if r.Stdout == "" && r.Stderr == "" {
+		b.WriteString("(no output)\n")
+	}
+	fmt.Fprintf(&b, "[%s]\n</output>", r.Status)
+	return b.String()
+}
+
+// executeCommand runs cmdStr with bash in its own process group. When ctx is
+// cancelled or the timeout passes, the whole group is killed, so pipelines
+// and background children do not outlive the command. Output beyond
+// MaxOutput keeps its beginning and end, with a marker in between. The error
+// is nil only when the command exited with status 0.
+func executeCommand(ctx context.Context, cmdStr string, opts CommandOptions) (ExecResult, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	cmd, err := newCommand(ctx, cmdStr, opts)
+	if err != nil {
+		return ExecResult{ExitCode: -1, Status: err.Error()}, err
+	}
+	var state *os.File
+	if opts.Shell != nil {
+		var cleanup func()
+		if state, cleanup, err = opts.Shell.prepare(cmd); err != nil {
+			return ExecResult{ExitCode: -1, Status: err.Error()}, err
+		}
+		defer cleanup()
+	}
+	addEnv(cmd, opts.Env)
+
+	out := &cappedBuffer{limit: opts.MaxOutput}
+	stderr := &cappedBuffer{limit: opts.MaxOutput}
+	cmd.Stdout, cmd.Stderr = out, stderr
+	if opts.OnLine != nil {
+		stdoutLines := &lineWriter{stream: "stdout", emit: opts.OnLine, limit: maxLiveLines}
+		stderrLines := &lineWriter{stream: "stderr", emit: opts.OnLine, limit: maxLiveLines}
+		defer stdoutLines.flush()
+		defer stderrLines.flush()
+		cmd.Stdout = io.MultiWriter(out, stdoutLines)
+		cmd.Stderr = io.MultiWriter(stderr, stderrLines)
+	}
+
+	start := time.Now()
+	err = cmd.Run()
+	res := ExecResult{
+		Stdout:   strings.TrimRight(out.String(), "\n"),
+		Stderr:   strings.TrimRight(stderr.String(), "\n"),
+		Duration: time.Since(start),
+	}
+	res.ExitCode, res.Signal, res.Status = exitStatus(cmd, err, res.Duration)
+	if opts.Shell != nil && ctx.Err() == nil {
+		opts.Shell.update(state, secretValues(opts.Env))
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("command timed out after %s and was killed", opts.Timeout)
+		res.Status = err.Error()
+	case errors.Is(ctx.Err(), context.Canceled):
+		err = errors.New("command cancelled by the user")
+		res.Status = err.Error()
+	}
+	return res, err
+}
+
+// newCommand builds the process for cmdStr: bash in a process group of its
+// own, or the sandbox, killed together with everything it started when ctx
+// is done.
+func newCommand(ctx context.Context, cmdStr string, opts CommandOptions) (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	if opts.Sandbox {
+		var err error
+		if cmd, err = sandboxCommand(ctx, cmdStr, opts); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd = exec.CommandContext(ctx, "bash", "-c", cmdStr)
+		setProcessGroup(cmd)
+	}
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	// Children that inherited the output pipes can keep them open after
+	// bash is gone; stop waiting for them shortly after the kill.
+	cmd.WaitDelay = 2 * time.Second
+	return cmd, nil
+}
+
+// addEnv adds variables to the environment of cmd, which starts from
+// commandEnviron when the command has none of its own yet.
+func addEnv(cmd *exec.Cmd, env []string) {
+	if cmd.Env == nil {
+		cmd.Env = commandEnviron()
+	}
+	cmd.Env = append(cmd.Env, env...)
+}
+
+// exitStatus describes how a finished command ended, given the error from
+// running it: its exit code, the signal that killed it if any, and a status
+// line. The exit code is -1 when the process did not exit on its own.
+func exitStatus(cmd *exec.Cmd, err error, elapsed time.Duration) (exitCode int, signal, status string) {
+	if cmd.ProcessState == nil {
+		return -1, "", fmt.Sprintf("command failed to run: %v", err)
+	}
+	exitCode = cmd.ProcessState.ExitCode()
+	signal = exitSignal(cmd.ProcessState)
+	elapsed = elapsed.Round(time.Millisecond)
+	if signal != "" {
+		return exitCode, signal, fmt.Sprintf("killed by %s after %s", signal, elapsed)
+	}
+	return exitCode, "", fmt.Sprintf("exit status %d after %s", exitCode, elapsed)
+}
+
+// lineWriter splits a stream into lines for live reporting.
+type lineWriter struct {
+	stream string
+	emit   func(stream, line string)
+	limit  int // lines to emit, 0 for all
+	buf    []byte
+	lines  int
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		switch {
+		case i >= 0:
+			w.line(string(bytes.TrimSuffix(w.buf[:i], []byte("\r"))))
+			w.buf = w.buf[i+1:]
+		case len(w.buf) >= maxLineLength:
+			w.line(string(w.buf[:maxLineLength]))
+			w.buf = w.buf[maxLineLength:]
+		default:
+			return len(p), nil
+		}
+	}
+}
+
+func (w *lineWriter) line(s string) {
+	w.lines++
+	switch {
+	case w.limit <= 0 || w.lines <= w.limit:
+		w.emit(w.stream, s)
+	case w.lines == w.limit+1:
+		w.emit(w.stream, fmt.Sprintf("... (more %s not shown live)", w.stream))
+	}
+}
+
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.line(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// cappedBuffer collects command output up to limit bytes, keeping the first
+// and last half of it and counting the bytes dropped in between.
+type cappedBuffer struct {
+	limit   int
+	head    []byte
+	tail    []byte
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit <= 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+	if room := b.limit/2 - len(b.head); room > 0 {
+		take := min(room, len(p))
+		b.head = append(b.head, p[:take]...)
+		p = p[take:]
+	}
+	b.tail = append(b.tail, p...)
+	if excess := len(b.tail) - (b.limit - b.limit/2); excess > 0 {
+		b.dropped += excess
+		b.tail = append(b.tail[:0], b.tail[excess:]...)
+	}
+	return n, nil
+}
+
+func (b *cappedBuffer) String() string {
+	if b.dropped == 0 {
+		return string(b.head) + string(b.tail)
+	}
+	return fmt.Sprintf("%s\n... [truncated %d bytes] ...\n%s", b.head, b.dropped, b.tail)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FilePolicy limits the paths the file actions (read, write, search) may
+// touch. Everything under Root is allowed unless Allow is set, in which case
+// only paths matching one of its globs are. Paths outside Root need an
+// absolute Allow glob ("/usr/share/dict/**", "~/notes/**"). Deny globs win
+// over both:
+//
+//	"files": {
+//	  "root": ".",
+//	  "deny": [".git/**", "*.pem", "~/.ssh/**"]
+//	}
+//
+// Globs follow .gitignore syntax: "*" stays within a path segment and "**"
+// crosses them; relative globs without a slash match the file name at any
+// depth. Root defaults to the directory shrew was started in.
+type FilePolicy struct {
+	Root  string   `json:"root,omitempty"`
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	allow, deny []fileGlob
+}
+
+// fileGlob is a compiled Allow or Deny glob.
+type fileGlob struct {
+	glob string
+	re   *regexp.Regexp
+	abs  bool // matches the absolute path
+	base bool // matches the file name
+}
+
+// validate checks that the root, when given, is a directory and compiles the
+// globs.
+func (f *FilePolicy) validate() error {
+	if f.Root != "" {
+		info, err := os.Stat(f.root())
+		if err != nil {
+			return fmt.Errorf("files root: %v", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("files root %s is not a directory", f.Root)
+		}
+	}
+	var err error
+	if f.allow, err = compileFileGlobs(f.Allow); err != nil {
+		return fmt.Errorf("files allow: %v", err)
+	}
+	if f.deny, err = compileFileGlobs(f.Deny); err != nil {
+		return fmt.Errorf("files deny: %v", err)
+	}
+	return nil
+}
+
+func compileFileGlobs(globs []string) ([]fileGlob, error) {
+	var compiled []fileGlob
+	for _, glob := range globs {
+		g := filepath.ToSlash(expandHome(strings.TrimSpace(glob)))
+		re, err := regexp.Compile("^" + globToRegexp(strings.TrimPrefix(g, "./")) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %v", glob, err)
+		}
+		compiled = append(compiled, fileGlob{
+			glob: glob,
+			re:   re,
+			abs:  filepath.IsAbs(filepath.FromSlash(g)),
+			base: !strings.Contains(g, "/"),
+		})
+	}
+	return compiled, nil
+}
+
+func (f *FilePolicy) root() string {
+	root := f.Root
+	if root == "" {
+		return workspaceRoot()
+	}
+	root = expandHome(root)
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(workspaceRoot(), root)
+	}
+	return filepath.Clean(root)
+}
+
+// checkPath resolves path, relative to cwd, for a file action and returns it
+// if the file rules allow it. Symlinks are resolved as well, so a link inside
+// the workspace cannot reach a file outside of it; both the path as written
+// and the file it leads to must be allowed. protected lists files that are
+// never accessible, such as shrew's own database.
+func (f *FilePolicy) checkPath(path, cwd string, isDir bool, protected []string) (string, error) {
+	if f == nil {
+		f = &FilePolicy{}
+	}
+	if len(f.allow) != len(f.Allow) || len(f.deny) != len(f.Deny) {
+		// Globs that were never compiled would not be enforced.
+		return "", fmt.Errorf("file rules were not validated")
+	}
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", fmt.Errorf("no path given")
+	}
+	abs := expandHome(path)
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(cwd, abs)
+	}
+	abs = filepath.Clean(abs)
+
+	root := f.root()
+	roots := []string{root}
+	if real := resolveExisting(root); real != root {
+		roots = append(roots, real)
+	}
+	var protectedPaths []string
+	for _, p := range protected {
+		protectedPaths = append(protectedPaths, p, resolveExisting(p))
+	}
+
+	if err := f.check(abs, abs, roots, isDir, protectedPaths); err != nil {
+		return "", err
+	}
+	if real := resolveExisting(abs); real != abs {
+		if err := f.check(path, real, roots, isDir, protectedPaths); err != nil {
+			return "", fmt.Errorf("%v (%s is a link to %s)", err, path, real)
+		}
+	}
+	return abs, nil
+}
+
+func (f *FilePolicy) check(name, abs string, roots []string, isDir bool, protected []string) error {
+	for _, p := range protected {
+		if abs == p {
+			return fmt.Errorf("access to %s refused: the file is managed by shrew", name)
+		}
+	}
+
+	rel, inside := "", false
+	for _, root := range roots {
+		if r, err := filepath.Rel(root, abs); err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			rel, inside = filepath.ToSlash(r), true
+			break
+		}
+	}
+	if glob, ok := matchFileGlob(f.deny, abs, rel, inside, isDir); ok {
+		return fmt.Errorf("access to %s refused by file rule %q", name, glob)
+	}
+
+	switch {
+	case !inside:
+		if _, ok := matchFileGlob(absoluteGlobs(f.allow), abs, rel, inside, isDir); !ok {
+			return fmt.Errorf("access to %s refused: it is outside the workspace %s", name, roots[0])
+		}
+	case len(f.Allow) > 0 && !isDir:
+		// Directories inside the workspace stay reachable so search can
+		// look for the allowed files within them.
+		if _, ok := matchFileGlob(f.allow, abs, rel, inside, isDir); !ok {
+			return fmt.Errorf("access to %s refused: it does not match any allowed path", name)
+		}
+	}
+	return nil
+}
+
+// matchFileGlob returns the first glob that matches a path. Absolute globs
+// are matched against the absolute path, the others against the path
+// relative to the workspace root, if it is inside it, and globs without a
+// slash against the file name. A directory also matches the globs that match
+// everything below it.
+func matchFileGlob(globs []fileGlob, abs, rel string, inside, isDir bool) (string, bool) {
+	candidates := func(p string) []string {
+		if isDir {
+			return []string{p, p + "/"}
+		}
+		return []string{p}
+	}
+	for _, g := range globs {
+		var target string
+		switch {
+		case g.abs:
+			target = filepath.ToSlash(abs)
+		case g.base:
+			target = filepath.Base(abs)
+		case inside:
+			target = rel
+		default:
+			continue
+		}
+		for _, t := range candidates(target) {
+			if g.re.MatchString(t) {
+				return g.glob, true
+			}
+		}
+	}
+	return "", false
+}
+
+func absoluteGlobs(globs []fileGlob) []fileGlob {
+	var abs []fileGlob
+	for _, g := range globs {
+		if g.abs {
+			abs = append(abs, g)
+		}
+	}
+	return abs
+}
+
+// resolveExisting resolves the symlinks in path. For a file that does not
+// exist yet, the longest existing parent is resolved and the rest appended;
+// a dangling link is followed to where its target would be created.
+func resolveExisting(path string) string {
+	return resolveLinks(path, 0)
+}
+
+func resolveLinks(path string, depth int) string {
+	rest := ""
+	for p := path; ; {
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(real, rest)
+		}
+		if info, err := os.Lstat(p); err == nil && info.Mode()&os.ModeSymlink != 0 && depth < 40 {
+			if target, err := os.Readlink(p); err == nil {
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(filepath.Dir(p), target)
+				}
+				return resolveLinks(filepath.Join(target, rest), depth+1)
+			}
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fileWorkspace lays out a workspace with shrew's own files and an outside
+// directory next to it, and returns both.
+func fileWorkspace(t *testing.T) (ws, outside string) {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, outside = filepath.Join(dir, "ws"), filepath.Join(dir, "outside")
+	for _, d := range []string{ws, filepath.Join(ws, "sub"), filepath.Join(ws, ".git"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{
+		filepath.Join(ws, "main.go"),
+		filepath.Join(ws, "sub", "notes.txt"),
+		filepath.Join(ws, "key.pem"),
+		filepath.Join(ws, "shrew.db"),
+		filepath.Join(ws, ".env"),
+		filepath.Join(ws, defaultPolicyFile),
+		filepath.Join(outside, "secret.txt"),
+	} {
+		if err := os.WriteFile(f, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ws, outside
+}
+
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+}
+
+func validFiles(t *testing.T, f *FilePolicy) *FilePolicy {
+	t.Helper()
+	if err := f.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCheckPath(t *testing.T) {
+	ws, outside := fileWorkspace(t)
+	symlink(t, filepath.Join(outside, "secret.txt"), filepath.Join(ws, "file-link"))
+	symlink(t, outside, filepath.Join(ws, "dir-link"))
+	symlink(t, filepath.Join(outside, "new.txt"), filepath.Join(ws, "dangling"))
+	symlink(t, "../../outside/secret.txt", filepath.Join(ws, "sub", "relative-link"))
+	symlink(t, filepath.Join(ws, "sub", "notes.txt"), filepath.Join(ws, "inner-link"))
+	symlink(t, "shrew.db", filepath.Join(ws, "db-link"))
+	symlink(t, ".env", filepath.Join(ws, "sub-env"))
+
+	e := &Engine{
+		Config: Config{PolicyFile: defaultPolicyFile},
+		Shell:  newShell(ws),
+		Policy: &Policy{Files: validFiles(t, &FilePolicy{Root: ws, Deny: []string{".git/**", "*.pem"}})},
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		ok    bool
+	}{
+		{"main.go", false, true},
+		{"sub/notes.txt", false, true},
+		{"sub", true, true},
+		{".", true, true},
+		{"new-file.txt", false, true},
+		{"inner-link", false, true},
+
+		// Outside the workspace, directly or through links.
+		{"../outside/secret.txt", false, false},
+		{filepath.Join(outside, "secret.txt"), false, false},
+		{"file-link", false, false},
+		{"dir-link/secret.txt", false, false},
+		{"dir-link", true, false},
+		{"dangling", false, false},
+		{"sub/relative-link", false, false},
+
+		// Deny rules.
+		{"key.pem", false, false},
+		{".git/config", false, false},
+
+		// Shrew's own files, however they are named.
+		{"shrew.db", false, false},
+		{".env", false, false},
+		{defaultPolicyFile, false, false},
+		{"sub/../.env", false, false},
+		{filepath.Join(ws, "shrew.db"), false, false},
+		{"db-link", false, false},
+		{"sub-env", false, false},
+	}
+	for _, tt := range tests {
+		_, err := e.checkPath(tt.path, tt.isDir)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkPath(%q) error = %v, want allowed %v", tt.path, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckPathAllow(t *testing.T) {
+	ws, outside := fileWorkspace(t)
+	files := validFiles(t, &FilePolicy{
+		Root:  ws,
+		Allow: []string{"*.go", "sub/**", filepath.ToSlash(outside) + "/**"},
+		Deny:  []string{"sub/private/**"},
+	})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		ok    bool
+	}{
+		{"main.go", false, true},
+		{"sub/notes.txt", false, true},
+		{"key.pem", false, false},
+		{"sub/private/a.txt", false, false},
+		{".git", true, true},
+		{filepath.Join(outside, "secret.txt"), false, true},
+		{"../outside/secret.txt", false, true},
+		{"shrew.db", false, false},
+	}
+	protected := []string{filepath.Join(ws, "shrew.db")}
+	for _, tt := range tests {
+		_, err := files.checkPath(tt.path, ws, tt.isDir, protected)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkPath(%q) error = %v, want allowed %v", tt.path, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckPathAbsolutePolicyFile(t *testing.T) {
+	ws, outside := fileWorkspace(t)
+	policyFile := filepath.Join(outside, "policy.json")
+	e := &Engine{
+		Config: Config{PolicyFile: policyFile},
+		Shell:  newShell(ws),
+		Policy: &Policy{Files: validFiles(t, &FilePolicy{Root: ws, Allow: []string{filepath.ToSlash(outside) + "/**"}})},
+	}
+	if _, err := e.checkPath(filepath.Join(outside, "secret.txt"), false); err != nil {
+		t.Errorf("allowed outside file refused: %v", err)
+	}
+	if _, err := e.checkPath(policyFile, false); err == nil {
+		t.Errorf("policy file outside the workspace was allowed")
+	}
+}
+
+func TestFilePolicyInvalidGlob(t *testing.T) {
+	for _, f := range []*FilePolicy{
+		{Deny: []string{"*.pem", "[z-a]"}},
+		{Allow: []string{"[z-a]/**"}},
+	} {
+		if err := f.validate(); err == nil {
+			t.Errorf("validate(%q, %q) succeeded", f.Allow, f.Deny)
+		}
+	}
+	if _, err := (&FilePolicy{Deny: []string{"*.pem"}}).checkPath("key.pem", t.TempDir(), false, nil); err == nil {
+		t.Errorf("checkPath with rules that were never validated succeeded")
+	}
+}
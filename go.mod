@@ -3,6 +3,6 @@ module github.com/Masmedeam/shrew
 go 1.25.0
 
 require (
-	go.etcd.io/bbolt v1.4.3 // indirect
-	golang.org/x/sys v0.29.0 // indirect
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/sys v0.29.0
 )
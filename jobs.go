@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxJobLines is how many lines of output a background job keeps; older
+// lines are dropped as new ones arrive.
+const maxJobLines = 2000
+
+// Job is a command running in the background, such as a dev server or a
+// file watcher. Its stdout and stderr are collected together, line by line,
+// and the model reads them incrementally.
+type Job struct {
+	ID      string
+	Command string
+	Started time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	lines    []string
+	dropped  int // lines discarded from the front of lines
+	read     int // lines handed to the model so far, counting dropped ones
+	exitCode *int
+	status   string
+}
+
+// JobInfo is a job's state as reported to the Web UI.
+type JobInfo struct {
+	ID       string   `json:"id"`
+	Command  string   `json:"command"`
+	Started  string   `json:"started"`
+	Running  bool     `json:"running"`
+	Status   string   `json:"status"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+	Tail     []string `json:"tail"`
+}
+
+// startJob runs cmdStr in the background with the same process setup as
+// foreground commands, but without a timeout. The job lives until it exits
+// or is killed, independently of the turn that started it.
+func (e *Engine) startJob(cmdStr, display string, opts CommandOptions) (*Job, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd, err := newCommand(ctx, cmdStr, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	cleanup := func() {}
+	if opts.Shell != nil {
+		if _, cleanup, err = opts.Shell.prepare(cmd); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	addEnv(cmd, opts.Env)
+
+	e.mu.Lock()
+	e.nextJobID++
+	job := &Job{
+		ID:      strconv.Itoa(e.nextJobID),
+		Command: display,
+		Started: time.Now(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		status:  "running",
+	}
+	e.mu.Unlock()
+
+	// Lines are kept redacted, as the model and the Web UI both read them.
+	emit := func(stream, line string) { job.appendLine(stream, e.Vault.Redact(line)) }
+	stdout := &lineWriter{stream: "stdout", emit: emit}
+	stderr := &lineWriter{stream: "stderr", emit: emit}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Start(); err != nil {
+		cleanup()
+		cancel()
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.jobs[job.ID] = job
+	e.mu.Unlock()
+
+	go func() {
+		err := cmd.Wait()
+		stdout.flush()
+		stderr.flush()
+		cleanup()
+		exitCode, _, status := exitStatus(cmd, err, time.Since(job.Started))
+		job.mu.Lock()
+		job.exitCode, job.status = &exitCode, status
+		job.mu.Unlock()
+		cancel()
+		close(job.done)
+		e.broadcast(Event{Type: EventJob, ID: job.ID, Content: fmt.Sprintf("Job %s finished: %s", job.ID, status), ExitCode: &exitCode})
+	}()
+	e.broadcast(Event{Type: EventJob, ID: job.ID, Content: fmt.Sprintf("Job %s started: %s", job.ID, display)})
+	return job, nil
+}
+
+func (j *Job) appendLine(_, line string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.lines = append(j.lines, line)
+	if extra := len(j.lines) - maxJobLines; extra > 0 {
+		j.lines = j.lines[extra:]
+		j.dropped += extra
+	}
+}
+
+// Running reports whether the job's process is still alive.
+func (j *Job) Running() bool {
+	select {
+	case <-j.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Kill stops the job and everything it started, and waits for it to exit.
+func (j *Job) Kill() {
+	j.cancel()
+	<-j.done
+}
+
+// unread returns the output the model has not seen yet, capped to limit
+// bytes, and marks it as read.
+func (j *Job) unread(limit int) string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var b strings.Builder
+	if j.read < j.dropped {
+		fmt.Fprintf(&b, "[%d earlier lines were discarded]\n", j.dropped-j.read)
+		j.read = j.dropped
+	}
+	buf := &cappedBuffer{limit: limit}
+	for _, line := range j.lines[j.read-j.dropped:] {
+		buf.Write([]byte(line + "\n"))
+	}
+	j.read = j.dropped + len(j.lines)
+	b.WriteString(buf.String())
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// Info describes the job with the last tail lines of its output.
+func (j *Job) Info(tail int) JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	lines := j.lines[max(0, len(j.lines)-tail):]
+	return JobInfo{
+		ID:       j.ID,
+		Command:  j.Command,
+		Started:  j.Started.Format(time.RFC3339),
+		Running:  j.exitCode == nil,
+		Status:   j.status,
+		ExitCode: j.exitCode,
+		Tail:     append([]string{}, lines...),
+	}
+}
+
+// xmlAttrs renders the job's id and state as attributes of the tags returned
+// to the model.
+func (j *Job) xmlAttrs() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.exitCode == nil {
+		return fmt.Sprintf(`id="%s" status="running"`, j.ID)
+	}
+	return fmt.Sprintf(`id="%s" status="%s" exit_code="%d"`, j.ID, j.status, *j.exitCode)
+}
+
+// Job returns the background job with the given ID.
+func (e *Engine) Job(id string) (*Job, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	job, ok := e.jobs[strings.TrimSpace(id)]
+	if !ok {
+		return nil, fmt.Errorf("no job with id %q", id)
+	}
+	return job, nil
+}
+
+// Jobs returns the session's background jobs, oldest first.
+func (e *Engine) Jobs() []*Job {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	jobs := make([]*Job, 0, len(e.jobs))
+	for _, job := range e.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Started.Before(jobs[k].Started) })
+	return jobs
+}
+
+// KillJobs stops every background job and forgets them. It runs when the
+// session ends: on switching sessions and when shrew exits.
+func (e *Engine) KillJobs() {
+	e.mu.Lock()
+	jobs := e.jobs
+	e.jobs = map[string]*Job{}
+	e.mu.Unlock()
+	for _, job := range jobs {
+		job.Kill()
+	}
+}
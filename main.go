@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"os"
@@ -12,7 +11,22 @@ const baseSystemPrompt = `You are "shrew", a minimalist AI agent.
 CRITICAL: Do not use Markdown (no bold, no italics, no markdown lists, no backticks for code).
 Respond in PLAIN TEXT only.
 To execute shell commands, wrap them in <run>tags: <run>ls -la</run>.
+Commands are killed after a timeout; for long builds or tests give more time in seconds: <run timeout="600">make test</run>.
+Command results come back as <output exit_code="..." duration="..."> with separate <stdout> and <stderr> sections, and a signal attribute when the command was killed. A non-zero exit code means the command failed.
+To read a file: <read>main.go</read>. Reads are limited in size; for large files read a range of lines with <read start="100" end="200">main.go</read>, or the end of a log with <read start="-50">app.log</read>. Binary files are summarised (type, size, sha256) and a directory is listed.
+To change part of an existing file, use an edit with one or more search/replace blocks instead of rewriting it; each SEARCH text must match the file exactly once:
+<edit path="main.go">
+<<<<<<< SEARCH
+	fmt.Println("old")
+=======
+	fmt.Println("new")
+>>>>>>> REPLACE
+</edit>
+A unified diff of the file inside <edit path="..."> works too.
 To reason, use <think>...</think> tags.
+You may use several action tags in one response; they run in the order written and their outputs come back together, each labelled with its action.
+To run something that keeps running, like a dev server or a watcher, start it in the background instead: <job_start>npm run dev</job_start> returns a job id.
+Read what a job printed since your last look with <job_output id="1"/>, list jobs and their status with <job_status/>, and stop one with <job_kill id="1"/>.
 Use standard CLI tools.
 
 VAULT:
@@ -23,7 +37,8 @@ To store a secret: <vault_set key="NAME" value="SECRET_VALUE"/>.
 To see which keys are available in the vault without seeing their values: <vault_list/>.
 If you need a secret but don't know the key name, use <vault_list/> first to help the user.
 Do not use <vault_get> if you only need the secret for a command.
-If you need to see a secret for other reasons, use <vault_get key="NAME"/>.
+If you need to see a secret for other reasons, use <vault_get key="NAME"/>. Most secrets can only be used in commands; the others need the user's approval each time.
+A secret may be bound to certain hosts or commands, and is refused in other commands.
 
 SKILLS:
 If you need documentation for an API, first check if you have it using <get_skill name="service_name"/>.
@@ -31,8 +46,14 @@ If not found, search for it (e.g., using curl) or ask the user.
 To save documentation for future use, use <save_skill name="service_name">DOCS_CONTENT</save_skill>.`
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == sandboxInitArg {
+		runSandboxInit(os.Args[2:])
+		return
+	}
+
 	listFlag := flag.Bool("list", false, "List all available sessions")
 	portFlag := flag.Int("port", 8080, "Port for the Web UI")
+	hostFlag := flag.String("host", "127.0.0.1", "Interface the Web UI listens on")
 	flag.Parse()
 
 	if *listFlag {
@@ -65,62 +86,62 @@ func main() {
 		APIURL:             os.Getenv("SHREW_API_URL"),
 		Model:              os.Getenv("SHREW_MODEL"),
 		CustomInstructions: os.Getenv("SHREW_CUSTOM_INSTRUCTIONS"),
+		NativeTools:        os.Getenv("SHREW_NATIVE_TOOLS") != "false",
+		StopOnError:        os.Getenv("SHREW_STOP_ON_ERROR") == "true",
+		RequireApproval:    os.Getenv("SHREW_APPROVAL") == "true",
+		PolicyFile:         os.Getenv("SHREW_POLICY"),
+		CommandTimeout:     parseSeconds(os.Getenv("SHREW_COMMAND_TIMEOUT"), defaultCommandTimeout),
+		MaxOutput:          parseBytes(os.Getenv("SHREW_MAX_OUTPUT"), defaultMaxOutput),
+		Sandbox:            os.Getenv("SHREW_SANDBOX") == "true",
+		VaultTimeout:       parseSeconds(os.Getenv("SHREW_VAULT_TIMEOUT"), defaultVaultTimeout),
 	}
 
 	if cfg.Model == "" {
 		cfg.Model = "gpt-4o"
 	}
-	if cfg.APIURL == "" {
-		cfg.APIURL = "https://api.openai.com/v1/chat/completions"
+	if cfg.PolicyFile == "" {
+		cfg.PolicyFile = defaultPolicyFile
+	}
+	policy, err := loadPolicy(cfg.PolicyFile)
+	if err != nil {
+		fmt.Printf("Error loading command policy: %v\n", err)
+		os.Exit(1)
 	}
 
 	sessionID := time.Now().Format("2006-01-02-15-04-05")
 	history := []Message{{Role: "user", Content: "Context: " + gatherContext()}}
-	
+
 	engine := NewEngine(cfg, baseSystemPrompt, sessionID, history, db)
+	engine.Policy = policy
+
+	// Unlock the vault before anything can ask for a secret: with the keyfile
+	// if there is one, otherwise by asking for the passphrase.
+	if keyfile := os.Getenv("SHREW_VAULT_KEYFILE"); keyfile != "" {
+		if err := engine.Vault.UseKeyfile(keyfile); err != nil {
+			fmt.Printf("Error unlocking the vault: %v\n", err)
+			os.Exit(1)
+		}
+	} else if status := engine.Vault.Status(); stdinIsTerminal() && (status.Initialized || status.Plaintext > 0) {
+		if !status.Initialized {
+			fmt.Printf("The vault holds %d secrets stored unencrypted.\n", status.Plaintext)
+		}
+		unlockVault(engine.Vault, readStdinLine)
+	}
+
 	server := NewServer(engine)
 
 	// Start Server
 	go func() {
-		if err := server.Start(*portFlag); err != nil {
+		if err := server.Start(*hostFlag, *portFlag); err != nil {
 			fmt.Printf("Server error: %v\n", err)
 		}
 	}()
 
 	// Terminal REPL
 	fmt.Printf("\nShrew is active.\n")
-	fmt.Printf("   Web UI: http://localhost:%d\n", *portFlag)
+	fmt.Printf("   Web UI: %s\n", server.URL(*hostFlag, *portFlag))
 	fmt.Printf("   Terminal: Type below and press Enter\n\n")
 
-	// Subscribe terminal to engine events
-	events := engine.Subscribe()
-	go func() {
-		for event := range events {
-			switch event.Type {
-			case EventThinking:
-				fmt.Print("...thinking")
-			case EventExecuting:
-				fmt.Printf("\n> [run]: %s\n", event.Content)
-			case EventOutput:
-				fmt.Printf("[output]: %s\n", event.Content)
-			case EventResponse:
-				fmt.Printf("\nshrew: %s\n\n", event.Content)
-			case EventError:
-				fmt.Printf("\nError: %s\n", event.Content)
-			}
-		}
-	}()
-
-	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		fmt.Print("> ")
-		if !scanner.Scan() {
-			break
-		}
-		input := scanner.Text()
-		if input == "" {
-			continue
-		}
-		engine.Process(input)
-	}
+	newREPL(engine).run()
+	engine.KillJobs()
 }
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const defaultPolicyFile = "shrew_policy.json"
+
+// Policy actions. "ask" sends the command to the user for approval.
+const (
+	PolicyAllow = "allow"
+	PolicyAsk   = "ask"
+	PolicyDeny  = "deny"
+)
+
+// Policy decides which shell commands run automatically, which need the
+// user's approval and which are refused. It is read from a JSON file:
+//
+//	{
+//	  "default": "ask",
+//	  "rules": [
+//	    {"name": "git-status", "action": "allow", "pattern": "git status"},
+//	    {"name": "no-pipe-to-shell", "action": "deny", "pattern": "curl * | sh"}
+//	  ]
+//	}
+//
+// Patterns are globs over the whole command where "*" matches anything,
+// spaces included; a trailing " *" also matches the bare command.
+//
+// Commands in the sandbox have no network access unless "network" is set on
+// the policy, or on the rules matching every part of the command.
+//
+// The "files" section limits the paths the file actions can reach; see
+// FilePolicy.
+type Policy struct {
+	Default string       `json:"default,omitempty"`
+	Network bool         `json:"network,omitempty"`
+	Rules   []PolicyRule `json:"rules"`
+	Files   *FilePolicy  `json:"files,omitempty"`
+}
+
+type PolicyRule struct {
+	Name    string `json:"name"`
+	Action  string `json:"action"`
+	Pattern string `json:"pattern"`
+	Network bool   `json:"network,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// PolicyDecision is the outcome for one command. Rule names the rules that
+// decided it and is empty when the default applied. Network reports whether
+// a sandboxed command may use the network.
+type PolicyDecision struct {
+	Action  string
+	Rule    string
+	Network bool
+}
+
+// loadPolicy reads the policy file at path. A missing file is an empty policy.
+func loadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Policy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := parsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return p, nil
+}
+
+// parsePolicy decodes and validates a policy document.
+func parsePolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, fmt.Errorf("invalid policy: %v", err)
+		}
+	}
+	if p.Default != "" && !validPolicyAction(p.Default) {
+		return nil, fmt.Errorf("invalid default action %q (want allow, ask or deny)", p.Default)
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		r.Pattern = strings.Join(strings.Fields(r.Pattern), " ")
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("rule %d has no pattern", i+1)
+		}
+		if !validPolicyAction(r.Action) {
+			return nil, fmt.Errorf("rule %q: invalid action %q (want allow, ask or deny)", r.Pattern, r.Action)
+		}
+		if r.Name == "" {
+			r.Name = r.Pattern
+		}
+		r.re = compilePolicyPattern(r.Pattern)
+	}
+	if p.Files != nil {
+		if err := p.Files.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// save writes the policy to path in the same format loadPolicy reads.
+func (p *Policy) save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func validPolicyAction(action string) bool {
+	return action == PolicyAllow || action == PolicyAsk || action == PolicyDeny
+}
+
+func compilePolicyPattern(pattern string) *regexp.Regexp {
+	optionalArgs := strings.HasSuffix(pattern, " *")
+	if optionalArgs {
+		pattern = strings.TrimSuffix(pattern, " *")
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i, part := range strings.Split(pattern, "*") {
+		if i > 0 {
+			b.WriteString(".*")
+		}
+		b.WriteString(regexp.QuoteMeta(part))
+	}
+	if optionalArgs {
+		b.WriteString("(?: .*)?")
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// Evaluate decides what to do with command. Deny rules are checked first
+// against the whole command line, each pipeline and each simple command in
+// it, so a denied command cannot hide behind "&&" or ";". Otherwise every
+// simple command must be allowed on its own for the line to run without
+// asking; the first allow or ask rule matching a simple command decides for
+// it, and commands no rule matches get fallback, or the policy's default
+// when it has one.
+func (p *Policy) Evaluate(command, fallback string) PolicyDecision {
+	if p.Default != "" {
+		fallback = p.Default
+	}
+	pipelines, simple := splitCommandLine(command)
+	candidates := append([]string{normalizeCommand(command)}, pipelines...)
+	candidates = append(candidates, simple...)
+	for _, r := range p.Rules {
+		if r.Action != PolicyDeny {
+			continue
+		}
+		for _, c := range candidates {
+			if r.re.MatchString(c) {
+				return PolicyDecision{Action: PolicyDeny, Rule: r.Name}
+			}
+		}
+	}
+
+	decision := PolicyDecision{Action: PolicyAllow}
+	var allowedBy []string
+	network := len(simple) > 0
+	for _, c := range simple {
+		action, rule, ruleNetwork := fallback, "", false
+		for _, r := range p.Rules {
+			if r.Action == PolicyDeny || !r.re.MatchString(c) {
+				continue
+			}
+			if r.Action == PolicyAllow && hasUnsafeExpansion(c) {
+				continue
+			}
+			action, rule, ruleNetwork = r.Action, r.Name, r.Network
+			break
+		}
+		network = network && ruleNetwork
+		switch {
+		case action == PolicyDeny:
+			return PolicyDecision{Action: PolicyDeny, Rule: rule}
+		case action == PolicyAsk && decision.Action == PolicyAllow:
+			decision = PolicyDecision{Action: PolicyAsk, Rule: rule}
+		case action == PolicyAllow && rule != "" && !slices.Contains(allowedBy, rule):
+			allowedBy = append(allowedBy, rule)
+		}
+	}
+	if len(simple) == 0 {
+		decision.Action = fallback
+	}
+	if decision.Action == PolicyAllow {
+		decision.Rule = strings.Join(allowedBy, ", ")
+	}
+	decision.Network = p.Network || network
+	return decision
+}
+
+// splitCommandLine breaks a shell command line into its pipelines (separated
+// by ";", "&&", "||", "&" or newlines) and the simple commands of those
+// pipelines (separated by "|"). Quoted and escaped separators are left alone.
+func splitCommandLine(command string) (pipelines, simple []string) {
+	var pipe, cmd strings.Builder
+	endCommand := func() {
+		if c := normalizeCommand(cmd.String()); c != "" {
+			simple = append(simple, c)
+		}
+		cmd.Reset()
+	}
+	endPipeline := func() {
+		endCommand()
+		if p := normalizeCommand(pipe.String()); p != "" {
+			pipelines = append(pipelines, p)
+		}
+		pipe.Reset()
+	}
+
+	var quote byte
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(command) {
+				pipe.WriteByte(c)
+				cmd.WriteByte(c)
+				i++
+				c = command[i]
+			}
+		case c == '\\' && i+1 < len(command):
+			pipe.WriteByte(c)
+			cmd.WriteByte(c)
+			i++
+			c = command[i]
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ';' || c == '\n':
+			endPipeline()
+			continue
+		case c == '&' && strings.HasPrefix(command[i:], "&&"):
+			endPipeline()
+			i++
+			continue
+		case c == '|' && strings.HasPrefix(command[i:], "||"):
+			endPipeline()
+			i++
+			continue
+		case c == '&' && (i == 0 || command[i-1] != '>' && command[i-1] != '<') && !strings.HasPrefix(command[i:], "&>"):
+			endPipeline()
+			continue
+		case c == '|':
+			endCommand()
+			if strings.HasPrefix(command[i:], "|&") {
+				pipe.WriteString(" |& ")
+				i++
+			} else {
+				pipe.WriteString(" | ")
+			}
+			continue
+		}
+		pipe.WriteByte(c)
+		cmd.WriteByte(c)
+	}
+	endPipeline()
+	return pipelines, simple
+}
+
+func normalizeCommand(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+var redirectRe = regexp.MustCompile(`>>?\s*([^\s&>]\S*)`)
+
+// hasUnsafeExpansion reports whether a simple command runs other commands
+// through substitution or writes to a file, which a pattern like "ls *"
+// should not silently allow. Redirects to /dev/null are fine.
+func hasUnsafeExpansion(c string) bool {
+	if strings.Contains(c, "$(") || strings.Contains(c, "`") || strings.Contains(c, "<(") || strings.Contains(c, ">(") {
+		return true
+	}
+	for _, m := range redirectRe.FindAllStringSubmatch(c, -1) {
+		if m[1] != "/dev/null" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	anthropicVersion   = "2023-06-01"
+	anthropicMaxTokens = 8192
+)
+
+// anthropicProvider talks to the native Messages API. The system prompt is a
+// top-level field and roles must alternate, so consecutive messages from the
+// same role (tool output following a user turn) are merged first.
+type anthropicProvider struct {
+	cfg Config
+}
+
+func init() {
+	registerProvider("anthropic", func(cfg Config) Provider {
+		return &anthropicProvider{cfg: cfg}
+	})
+}
+
+func (p *anthropicProvider) Capabilities() Capabilities {
+	return Capabilities{Streaming: true, ToolCalling: true, ModelDiscovery: true}
+}
+
+func (p *anthropicProvider) headers() map[string]string {
+	h := map[string]string{"anthropic-version": anthropicVersion}
+	if p.cfg.APIKey != "" {
+		h["x-api-key"] = p.cfg.APIKey
+	}
+	return h
+}
+
+func (p *anthropicProvider) request(req CompletionRequest, stream bool) AnthropicRequest {
+	ar := AnthropicRequest{
+		Model:     req.Model,
+		System:    req.System,
+		MaxTokens: anthropicMaxTokens,
+		Stream:    stream,
+		Messages:  anthropicMessages(req.Messages),
+	}
+	for _, t := range req.Tools {
+		ar.Tools = append(ar.Tools, AnthropicTool{Name: t.Name, Description: t.Description, InputSchema: t.Schema()})
+	}
+	return ar
+}
+
+// anthropicMessages converts the history to content blocks. Tool results
+// travel as tool_result blocks in a user turn, and adjacent turns from the
+// same role are merged because the API requires strict alternation.
+func anthropicMessages(history []Message) []AnthropicMessage {
+	var messages []AnthropicMessage
+	for _, m := range history {
+		role := m.Role
+		var blocks []AnthropicBlock
+		if m.Role == "tool" {
+			role = "user"
+			blocks = append(blocks, AnthropicBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content})
+		} else if m.Content != "" {
+			blocks = append(blocks, AnthropicBlock{Type: "text", Text: m.Content})
+		}
+		for _, call := range m.ToolCalls {
+			blocks = append(blocks, AnthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: encodeToolArgs(call.Arguments)})
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content, blocks...)
+			continue
+		}
+		messages = append(messages, AnthropicMessage{Role: role, Content: blocks})
+	}
+	return messages
+}
+
+// anthropicMessage turns response content blocks back into a message.
+func anthropicMessage(blocks []AnthropicBlock) (Message, error) {
+	msg := Message{Role: "assistant"}
+	var text strings.Builder
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Arguments: decodeToolArgs(block.Input)})
+		}
+	}
+	msg.Content = text.String()
+	if msg.Content == "" && len(msg.ToolCalls) == 0 {
+		return Message{}, fmt.Errorf("no response")
+	}
+	return msg, nil
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, req CompletionRequest) (Message, error) {
+	resp, err := doJSON(ctx, "POST", resolveEndpoint(p.cfg, "anthropic", req.Model), p.headers(), p.request(req, false))
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	var result AnthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Message{}, err
+	}
+	return anthropicMessage(result.Content)
+}
+
+// Stream reads the Messages API event stream. Each content block is opened
+// by content_block_start and filled by content_block_delta events: text
+// deltas for text blocks, partial JSON for tool_use input.
+func (p *anthropicProvider) Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) (Message, error) {
+	resp, err := doJSON(ctx, "POST", resolveEndpoint(p.cfg, "anthropic", req.Model), p.headers(), p.request(req, true))
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	var blocks []AnthropicBlock
+	var inputs []string
+	err = readSSE(resp.Body, func(event, data string) error {
+		var chunk struct {
+			Index        int            `json:"index"`
+			ContentBlock AnthropicBlock `json:"content_block"`
+			Delta        struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				PartialJSON string `json:"partial_json"`
+			} `json:"delta"`
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+		switch event {
+		case "content_block_start":
+			for len(blocks) <= chunk.Index {
+				blocks = append(blocks, AnthropicBlock{})
+				inputs = append(inputs, "")
+			}
+			blocks[chunk.Index] = chunk.ContentBlock
+			blocks[chunk.Index].Input = nil
+		case "content_block_delta":
+			if chunk.Index >= len(blocks) {
+				return nil
+			}
+			switch chunk.Delta.Type {
+			case "text_delta":
+				blocks[chunk.Index].Text += chunk.Delta.Text
+				onDelta(chunk.Delta.Text)
+			case "input_json_delta":
+				inputs[chunk.Index] += chunk.Delta.PartialJSON
+			}
+		case "error":
+			return fmt.Errorf("anthropic error: %s", chunk.Error.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	for i := range blocks {
+		if blocks[i].Type == "tool_use" {
+			blocks[i].Input = json.RawMessage(inputs[i])
+		}
+	}
+	return anthropicMessage(blocks)
+}
+
+// ListModels queries /v1/models, the sibling of the /v1/messages endpoint.
+func (p *anthropicProvider) ListModels(ctx context.Context) ([]string, error) {
+	endpoint := strings.TrimSuffix(resolveEndpoint(p.cfg, "anthropic", ""), "/messages") + "/models"
+	resp, err := doJSON(ctx, "GET", endpoint, p.headers(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	var models []string
+	for _, m := range result.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// geminiProvider talks to the native generateContent API. Gemini takes the
+// system prompt separately, names the assistant role "model" and
+// authenticates with a key query parameter instead of a bearer token.
+type geminiProvider struct {
+	cfg Config
+}
+
+func init() {
+	registerProvider("gemini", func(cfg Config) Provider {
+		return &geminiProvider{cfg: cfg}
+	})
+}
+
+func (p *geminiProvider) Capabilities() Capabilities {
+	return Capabilities{Streaming: true, ToolCalling: true, ModelDiscovery: true}
+}
+
+// withKey adds the API key to a Gemini URL.
+func (p *geminiProvider) withKey(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if p.cfg.APIKey != "" {
+		q := u.Query()
+		q.Set("key", p.cfg.APIKey)
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+func (p *geminiProvider) request(req CompletionRequest) GeminiRequest {
+	gr := GeminiRequest{
+		SystemInstruction: &GeminiContent{Parts: []GeminiPart{{Text: req.System}}},
+	}
+	for _, m := range req.Messages {
+		role := "user"
+		var parts []GeminiPart
+		switch {
+		case m.Role == "tool":
+			parts = append(parts, GeminiPart{FunctionResponse: &GeminiFunctionResponse{
+				Name:     m.Name,
+				Response: map[string]interface{}{"content": m.Content},
+			}})
+		case m.Content != "":
+			parts = append(parts, GeminiPart{Text: m.Content})
+		}
+		if m.Role == "assistant" {
+			role = "model"
+			for _, call := range m.ToolCalls {
+				parts = append(parts, GeminiPart{FunctionCall: &GeminiFunctionCall{Name: call.Name, Args: encodeToolArgs(call.Arguments)}})
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		// Function responses for one turn must arrive together
+		if n := len(gr.Contents); n > 0 && gr.Contents[n-1].Role == role {
+			gr.Contents[n-1].Parts = append(gr.Contents[n-1].Parts, parts...)
+			continue
+		}
+		gr.Contents = append(gr.Contents, GeminiContent{Role: role, Parts: parts})
+	}
+
+	if len(req.Tools) > 0 {
+		var decls []GeminiFunctionDeclaration
+		for _, t := range req.Tools {
+			decl := GeminiFunctionDeclaration{Name: t.Name, Description: t.Description}
+			if len(t.Params) > 0 {
+				decl.Parameters = t.Schema()
+			}
+			decls = append(decls, decl)
+		}
+		gr.Tools = []GeminiTool{{FunctionDeclarations: decls}}
+	}
+	return gr
+}
+
+func (p *geminiProvider) Complete(ctx context.Context, req CompletionRequest) (Message, error) {
+	endpoint, err := p.withKey(resolveEndpoint(p.cfg, "gemini", req.Model))
+	if err != nil {
+		return Message{}, err
+	}
+
+	resp, err := doJSON(ctx, "POST", endpoint, nil, p.request(req))
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	var result GeminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{Role: "assistant", Content: result.text(), ToolCalls: result.toolCalls()}
+	if msg.Content == "" && len(msg.ToolCalls) == 0 {
+		return Message{}, fmt.Errorf("no response")
+	}
+	return msg, nil
+}
+
+// Stream uses streamGenerateContent with alt=sse, which sends a sequence of
+// partial GeminiResponse objects as server-sent events.
+func (p *geminiProvider) Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) (Message, error) {
+	endpoint := strings.Replace(resolveEndpoint(p.cfg, "gemini", req.Model), ":generateContent", ":streamGenerateContent", 1)
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return Message{}, err
+	}
+	q := u.Query()
+	q.Set("alt", "sse")
+	u.RawQuery = q.Encode()
+	endpoint, err = p.withKey(u.String())
+	if err != nil {
+		return Message{}, err
+	}
+
+	resp, err := doJSON(ctx, "POST", endpoint, nil, p.request(req))
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var calls []ToolCall
+	err = readSSE(resp.Body, func(_, data string) error {
+		var chunk GeminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+		if delta := chunk.text(); delta != "" {
+			text.WriteString(delta)
+			onDelta(delta)
+		}
+		calls = append(calls, chunk.toolCalls()...)
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{Role: "assistant", Content: text.String(), ToolCalls: calls}
+	if msg.Content == "" && len(msg.ToolCalls) == 0 {
+		return Message{}, fmt.Errorf("no response")
+	}
+	return msg, nil
+}
+
+// toolCalls returns the function calls of the first candidate. Gemini does
+// not identify calls, so IDs are generated to pair them with their results.
+func (r GeminiResponse) toolCalls() []ToolCall {
+	if len(r.Candidates) == 0 {
+		return nil
+	}
+	var calls []ToolCall
+	for _, part := range r.Candidates[0].Content.Parts {
+		if part.FunctionCall != nil {
+			calls = append(calls, ToolCall{ID: newToolCallID(), Name: part.FunctionCall.Name, Arguments: decodeToolArgs(part.FunctionCall.Args)})
+		}
+	}
+	return calls
+}
+
+// text joins the text parts of the first candidate.
+func (r GeminiResponse) text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	return text.String()
+}
+
+// ListModels returns the models that support generateContent. The list
+// endpoint is the parent of the per-model path in the registry.
+func (p *geminiProvider) ListModels(ctx context.Context) ([]string, error) {
+	base := resolveEndpoint(p.cfg, "gemini", "")
+	if i := strings.Index(base, "/models/"); i >= 0 {
+		base = base[:i]
+	}
+	endpoint, err := p.withKey(base + "/models")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doJSON(ctx, "GET", endpoint, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Models []struct {
+			Name    string   `json:"name"`
+			Methods []string `json:"supportedGenerationMethods"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	var models []string
+	for _, m := range result.Models {
+		for _, method := range m.Methods {
+			if method == "generateContent" {
+				models = append(models, strings.TrimPrefix(m.Name, "models/"))
+				break
+			}
+		}
+	}
+	return models, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ollamaProvider talks to a local Ollama server's /api/chat endpoint. Replies
+// are streamed as newline-delimited JSON objects, each carrying a fragment of
+// the assistant message, until one arrives with done set.
+type ollamaProvider struct {
+	cfg Config
+}
+
+func init() {
+	registerProvider("ollama", func(cfg Config) Provider {
+		return &ollamaProvider{cfg: cfg}
+	})
+}
+
+func (p *ollamaProvider) Capabilities() Capabilities {
+	return Capabilities{Streaming: true, ToolCalling: true, ModelDiscovery: true}
+}
+
+func (p *ollamaProvider) Complete(ctx context.Context, req CompletionRequest) (Message, error) {
+	return p.Stream(ctx, req, func(string) {})
+}
+
+func (p *ollamaProvider) Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) (Message, error) {
+	messages := []OllamaMessage{{Role: "system", Content: req.System}}
+	for _, m := range req.Messages {
+		om := OllamaMessage{Role: m.Role, Content: m.Content}
+		if m.Role == "tool" {
+			om.ToolName = m.Name
+		}
+		for _, call := range m.ToolCalls {
+			var tc OllamaToolCall
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = encodeToolArgs(call.Arguments)
+			om.ToolCalls = append(om.ToolCalls, tc)
+		}
+		messages = append(messages, om)
+	}
+
+	or := OllamaRequest{Model: req.Model, Messages: messages, Stream: true}
+	if len(req.Tools) > 0 {
+		or.Tools = openAITools(req.Tools)
+	}
+	resp, err := doJSON(ctx, "POST", resolveEndpoint(p.cfg, "ollama", req.Model), nil, or)
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var calls []ToolCall
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var chunk OllamaResponse
+		if err := dec.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return Message{}, err
+		}
+		if chunk.Error != "" {
+			return Message{}, fmt.Errorf("ollama error: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			text.WriteString(chunk.Message.Content)
+			onDelta(chunk.Message.Content)
+		}
+		// Ollama does not identify tool calls, so IDs are generated
+		for _, tc := range chunk.Message.ToolCalls {
+			calls = append(calls, ToolCall{ID: newToolCallID(), Name: tc.Function.Name, Arguments: decodeToolArgs(tc.Function.Arguments)})
+		}
+		if chunk.Done {
+			break
+		}
+	}
+
+	msg := Message{Role: "assistant", Content: text.String(), ToolCalls: calls}
+	if msg.Content == "" && len(msg.ToolCalls) == 0 {
+		return Message{}, fmt.Errorf("no response")
+	}
+	return msg, nil
+}
+
+// ListModels asks the server which models are installed locally via
+// /api/tags.
+func (p *ollamaProvider) ListModels(ctx context.Context) ([]string, error) {
+	u, err := url.Parse(resolveEndpoint(p.cfg, "ollama", ""))
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/api/tags"
+	u.RawQuery = ""
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	resp, err := doJSON(ctx, "GET", u.String(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result OllamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	var models []string
+	for _, m := range result.Models {
+		models = append(models, m.Name)
+	}
+	return models, nil
+}
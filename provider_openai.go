@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// openAIProvider speaks the chat-completions schema. Besides OpenAI itself it
+// serves every registry entry that exposes an OpenAI-compatible endpoint.
+type openAIProvider struct {
+	id  string
+	cfg Config
+}
+
+func init() {
+	for _, id := range []string{"openai", "deepseek", "groq", "mistral"} {
+		id := id
+		registerProvider(id, func(cfg Config) Provider {
+			return &openAIProvider{id: id, cfg: cfg}
+		})
+	}
+}
+
+func (p *openAIProvider) Capabilities() Capabilities {
+	return Capabilities{Streaming: true, ToolCalling: true, ModelDiscovery: true}
+}
+
+func (p *openAIProvider) headers() map[string]string {
+	h := map[string]string{}
+	if p.cfg.APIKey != "" {
+		h["Authorization"] = "Bearer " + p.cfg.APIKey
+	}
+	return h
+}
+
+func (p *openAIProvider) request(req CompletionRequest, stream bool) map[string]interface{} {
+	messages := []OpenAIMessage{{Role: "system", Content: req.System}}
+	for _, m := range req.Messages {
+		om := OpenAIMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			tc := OpenAIToolCall{ID: call.ID, Type: "function"}
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = string(encodeToolArgs(call.Arguments))
+			om.ToolCalls = append(om.ToolCalls, tc)
+		}
+		messages = append(messages, om)
+	}
+
+	body := map[string]interface{}{
+		"model":    req.Model,
+		"messages": messages,
+	}
+	if stream {
+		body["stream"] = true
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = openAITools(req.Tools)
+	}
+	return body
+}
+
+// openAITools converts tool definitions to the function-tool format shared
+// by OpenAI-compatible servers and Ollama.
+func openAITools(tools []Tool) []OpenAITool {
+	var defs []OpenAITool
+	for _, t := range tools {
+		def := OpenAITool{Type: "function"}
+		def.Function.Name = t.Name
+		def.Function.Description = t.Description
+		def.Function.Parameters = t.Schema()
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, req CompletionRequest) (Message, error) {
+	resp, err := doJSON(ctx, "POST", resolveEndpoint(p.cfg, p.id, req.Model), p.headers(), p.request(req, false))
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Choices []struct {
+			Message OpenAIMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Message{}, err
+	}
+
+	if len(result.Choices) == 0 {
+		return Message{}, fmt.Errorf("no response")
+	}
+	choice := result.Choices[0].Message
+	msg := Message{Role: "assistant", Content: choice.Content}
+	for _, tc := range choice.ToolCalls {
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Arguments: decodeToolArgs([]byte(tc.Function.Arguments))})
+	}
+	return msg, nil
+}
+
+// Stream reads the chat-completions event stream. Tool calls arrive in
+// fragments keyed by index, with the arguments JSON split across chunks.
+func (p *openAIProvider) Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) (Message, error) {
+	resp, err := doJSON(ctx, "POST", resolveEndpoint(p.cfg, p.id, req.Model), p.headers(), p.request(req, true))
+	if err != nil {
+		return Message{}, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var calls []OpenAIToolCall
+	err = readSSE(resp.Body, func(_, data string) error {
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index int `json:"index"`
+						OpenAIToolCall
+					} `json:"tool_calls"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			text.WriteString(delta.Content)
+			onDelta(delta.Content)
+		}
+		for _, tc := range delta.ToolCalls {
+			for len(calls) <= tc.Index {
+				calls = append(calls, OpenAIToolCall{})
+			}
+			c := &calls[tc.Index]
+			if tc.ID != "" {
+				c.ID = tc.ID
+			}
+			c.Function.Name += tc.Function.Name
+			c.Function.Arguments += tc.Function.Arguments
+		}
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{Role: "assistant", Content: text.String()}
+	for _, tc := range calls {
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Arguments: decodeToolArgs([]byte(tc.Function.Arguments))})
+	}
+	if msg.Content == "" && len(msg.ToolCalls) == 0 {
+		return Message{}, fmt.Errorf("no response")
+	}
+	return msg, nil
+}
+
+// ListModels queries the /models endpoint that sits next to
+// /chat/completions on OpenAI-compatible servers.
+func (p *openAIProvider) ListModels(ctx context.Context) ([]string, error) {
+	endpoint := strings.TrimSuffix(resolveEndpoint(p.cfg, p.id, ""), "/chat/completions") + "/models"
+	resp, err := doJSON(ctx, "GET", endpoint, p.headers(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	var models []string
+	for _, m := range result.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
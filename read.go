@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// readMaxEntries bounds a directory listing.
+const readMaxEntries = 500
+
+// ReadOptions selects part of a file. Lines are numbered from 1; a negative
+// Start counts from the end, so -100 is the last hundred lines. End 0 reads
+// to the end of the file and MaxBytes 0 does not limit the size.
+type ReadOptions struct {
+	Start, End int
+	MaxBytes   int
+}
+
+// readFileRange reads the lines of a text file that opts selects. A binary
+// file is summarised instead: its type, size and SHA-256. The result is the
+// body of the <output> returned to the model, and its attributes.
+func readFileRange(path string, opts ReadOptions) (body, attrs string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 8000)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", "", err
+	}
+	head = head[:n]
+	if isBinary(head) {
+		return binarySummary(f, head)
+	}
+
+	start := opts.Start
+	if start < 0 {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", "", err
+		}
+		r, err := readLines(f, 1, -1, 0)
+		if err != nil {
+			return "", "", err
+		}
+		start = max(1, r.total+start+1)
+	}
+	start = max(start, 1)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	r, err := readLines(f, start, opts.End, opts.MaxBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	ranged := start > 1 || opts.End > 0 && opts.End < r.total
+	if !ranged && !r.truncated {
+		return r.text, "", nil
+	}
+	if r.last == 0 {
+		return fmt.Sprintf("[the file has %d lines]", r.total), fmt.Sprintf(` total_lines="%d"`, r.total), nil
+	}
+	attrs = fmt.Sprintf(` lines="%d-%d" total_lines="%d"`, start, r.last, r.total)
+	body = strings.TrimSuffix(r.text, "\n")
+	switch {
+	case r.truncated && r.last == start:
+		body += fmt.Sprintf("\n[line %d is longer than the %d byte limit and was cut]", r.last, opts.MaxBytes)
+	case r.truncated:
+		body += fmt.Sprintf("\n[output limited to %d bytes; line %d was cut, continue from there]", opts.MaxBytes, r.last)
+	}
+	return body, attrs, nil
+}
+
+type lineRange struct {
+	text      string
+	last      int // the last line read, 0 if none
+	total     int
+	truncated bool
+}
+
+// readLines reads lines start to end (inclusive; end 0 for all of them) of r,
+// up to limit bytes, and counts the lines of the whole file. It reads in
+// chunks, so a large file or a long line is never held in memory entirely.
+// With end -1 it only counts.
+func readLines(r io.Reader, start, end, limit int) (lineRange, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	var out bytes.Buffer
+	var res lineRange
+	line, atLineStart := 1, true
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if len(chunk) > 0 {
+			if atLineStart {
+				res.total = line
+			}
+			if line >= start && (end == 0 || line <= end) && !res.truncated {
+				if limit > 0 && out.Len()+len(chunk) > limit {
+					cut := limit - out.Len()
+					for cut > 0 && !utf8.RuneStart(chunk[cut]) {
+						cut--
+					}
+					out.Write(chunk[:cut])
+					res.truncated = true
+				} else {
+					out.Write(chunk)
+				}
+				res.last = line
+			}
+			atLineStart = chunk[len(chunk)-1] == '\n'
+			if atLineStart {
+				line++
+			}
+		}
+		switch err {
+		case nil, bufio.ErrBufferFull:
+		case io.EOF:
+			res.text = out.String()
+			return res, nil
+		default:
+			return res, err
+		}
+	}
+}
+
+// binarySummary describes a binary file whose first bytes are head and whose
+// rest is still to be read from f.
+func binarySummary(f *os.File, head []byte) (body, attrs string, err error) {
+	h := sha256.New()
+	h.Write(head)
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", "", err
+	}
+	size += int64(len(head))
+	kind := http.DetectContentType(head)
+	body = fmt.Sprintf("Binary file, content not shown.\ntype: %s\nsize: %d bytes\nsha256: %s", kind, size, hex.EncodeToString(h.Sum(nil)))
+	return body, ` type="binary"`, nil
+}
+
+// listDir lists a directory, one entry per line: subdirectories end in a
+// slash, files show their size and links their target. Entries allow refuses
+// are left out.
+func listDir(dir string, allow func(path string, isDir bool) bool) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var lines []string
+	for i, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if allow != nil && !allow(path, entry.IsDir()) {
+			continue
+		}
+		if len(lines) == readMaxEntries {
+			lines = append(lines, fmt.Sprintf("[%d more entries not shown]", len(entries)-i))
+			break
+		}
+		info, err := entry.Info()
+		switch {
+		case err != nil:
+			lines = append(lines, entry.Name())
+		case info.Mode()&os.ModeSymlink != 0:
+			target, _ := os.Readlink(path)
+			lines = append(lines, fmt.Sprintf("%s -> %s", entry.Name(), target))
+		case entry.IsDir():
+			lines = append(lines, entry.Name()+"/")
+		default:
+			lines = append(lines, fmt.Sprintf("%s (%d bytes)", entry.Name(), info.Size()))
+		}
+	}
+	if len(lines) == 0 {
+		return "(empty directory)", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
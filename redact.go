@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// minRedactLength is the shortest secret value that is redacted; shorter
+// ones would match all over ordinary output.
+const minRedactLength = 4
+
+// rollBase is the multiplier of the rolling hash that finds candidate
+// matches.
+const rollBase = 1099511628211
+
+// valuePrint stands for one form of a secret value without holding it: its
+// length, a rolling hash to spot candidates quickly and a SHA-256 to confirm
+// them.
+type valuePrint struct {
+	n    int
+	roll uint64
+	sum  [sha256.Size]byte
+}
+
+// redactIndex finds the forms of the known values in text by their prints.
+type redactIndex struct {
+	lengths []int // longest first
+	prints  map[int]*lengthPrints
+}
+
+// lengthPrints holds the prints of one length. The filter has a bit set for
+// the top bits of each rolling hash and rules out most positions without a
+// map lookup.
+type lengthPrints struct {
+	filter [1 << 10]uint64
+	byRoll map[uint64][]namedPrint
+}
+
+func (lp *lengthPrints) mayHave(h uint64) bool {
+	top := h >> 48
+	return lp.filter[top>>6]&(1<<(top&63)) != 0
+}
+
+// lookup returns the secret whose form text is, given its rolling hash h.
+func (lp *lengthPrints) lookup(h uint64, text string) (string, bool) {
+	candidates := lp.byRoll[h]
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(text))
+	for _, c := range candidates {
+		if c.sum == sum {
+			return c.name, true
+		}
+	}
+	return "", false
+}
+
+type namedPrint struct {
+	name string
+	sum  [sha256.Size]byte
+}
+
+// Redact replaces every vault value in s, as is or base64 or URL encoded,
+// with its [[vault:NAME]] placeholder. It knows the values of plaintext
+// secrets and of those decrypted since the process started, which includes
+// all of them once the vault has been unlocked. Values stay known after the
+// vault locks again, since a command started before can still print them,
+// but only by their hashes; the plaintexts are not kept.
+func (v *Vault) Redact(s string) string {
+	if s == "" {
+		return s
+	}
+	return v.redactor().redact(s)
+}
+
+// redactor returns the index of the known values, building it when the
+// secrets changed.
+func (v *Vault) redactor() *redactIndex {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.index != nil {
+		return v.index
+	}
+	prints := map[string][]valuePrint{}
+	for name, p := range v.known {
+		prints[name] = p
+	}
+	secrets, _ := v.DB.ListSecrets()
+	for name, data := range secrets {
+		if !bytes.HasPrefix(data, sealedPrefix) {
+			prints[name] = printValue(string(data))
+		}
+	}
+
+	// Visit names in order, so a value shared by several secrets is always
+	// redacted as the same one.
+	names := make([]string, 0, len(prints))
+	for name := range prints {
+		if !redactedName(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	x := &redactIndex{prints: map[int]*lengthPrints{}}
+	for _, name := range names {
+		for _, p := range prints[name] {
+			lp := x.prints[p.n]
+			if lp == nil {
+				lp = &lengthPrints{byRoll: map[uint64][]namedPrint{}}
+				x.prints[p.n] = lp
+				x.lengths = append(x.lengths, p.n)
+			}
+			if !slices.ContainsFunc(lp.byRoll[p.roll], func(np namedPrint) bool { return np.sum == p.sum }) {
+				lp.byRoll[p.roll] = append(lp.byRoll[p.roll], namedPrint{name, p.sum})
+				top := p.roll >> 48
+				lp.filter[top>>6] |= 1 << (top & 63)
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(x.lengths)))
+	v.index = x
+	return x
+}
+
+// redactedName reports whether the value of a vault entry is redacted.
+// Settings kept in the vault by earlier versions, such as the model name or
+// the output limit, are not secret and would match all over ordinary output;
+// the API key is the exception.
+func redactedName(name string) bool {
+	return name == "SHREW_API_KEY" || !configKeys[name]
+}
+
+// redact replaces the known values in s. Longer values are looked for first,
+// so a value that contains another one is replaced whole.
+func (x *redactIndex) redact(s string) string {
+	type match struct {
+		start, end int
+		name       string
+	}
+	var matches []match
+	var taken []bool
+	for _, n := range x.lengths {
+		if n > len(s) {
+			continue
+		}
+		lp := x.prints[n]
+		pow := uint64(1)
+		for range n {
+			pow *= rollBase
+		}
+		h := rollHash(s[:n])
+		for i := 0; ; i++ {
+			if lp.mayHave(h) && (taken == nil || !slices.Contains(taken[i:i+n], true)) {
+				if name, ok := lp.lookup(h, s[i:i+n]); ok {
+					if taken == nil {
+						taken = make([]bool, len(s))
+					}
+					for j := i; j < i+n; j++ {
+						taken[j] = true
+					}
+					matches = append(matches, match{i, i + n, name})
+				}
+			}
+			if i+n >= len(s) {
+				break
+			}
+			h = h*rollBase + uint64(s[i+n]) - uint64(s[i])*pow
+		}
+	}
+	if len(matches) == 0 {
+		return s
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(s[last:m.start])
+		b.WriteString("[[vault:" + m.name + "]]")
+		last = m.end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func rollHash(s string) uint64 {
+	var h uint64
+	for i := 0; i < len(s); i++ {
+		h = h*rollBase + uint64(s[i])
+	}
+	return h
+}
+
+// printValue returns the prints of the forms of a value, or none if it is
+// too short to redact.
+func printValue(value string) []valuePrint {
+	if len(value) < minRedactLength {
+		return nil
+	}
+	var prints []valuePrint
+	for _, text := range secretForms(value) {
+		p := valuePrint{n: len(text), roll: rollHash(text), sum: sha256.Sum256([]byte(text))}
+		if !slices.Contains(prints, p) {
+			prints = append(prints, p)
+		}
+	}
+	return prints
+}
+
+// secretForms lists the ways a value commonly shows up in output: raw, base64
+// with and without padding, and URL encoded.
+func secretForms(value string) []string {
+	raw := []byte(value)
+	return []string{
+		value,
+		base64.StdEncoding.EncodeToString(raw),
+		base64.RawStdEncoding.EncodeToString(raw),
+		base64.URLEncoding.EncodeToString(raw),
+		base64.RawURLEncoding.EncodeToString(raw),
+		url.QueryEscape(value),
+		url.PathEscape(value),
+	}
+}
+
+// remember records the prints of a decrypted value for Redact. The caller
+// holds v.mu.
+func (v *Vault) remember(name, value string) {
+	if v.known == nil {
+		v.known = map[string][]valuePrint{}
+	}
+	if p := printValue(value); !slices.Equal(v.known[name], p) {
+		v.known[name] = p
+		v.index = nil
+	}
+}
+
+// rememberAll decrypts every secret with key for Redact. The caller holds
+// v.mu.
+func (v *Vault) rememberAll(key []byte) {
+	secrets, err := v.DB.ListSecrets()
+	if err != nil {
+		return
+	}
+	for name, data := range secrets {
+		if !bytes.HasPrefix(data, sealedPrefix) {
+			continue
+		}
+		if plain, err := openSecret(key, name, data); err == nil {
+			v.remember(name, string(plain))
+			clear(plain)
+		}
+	}
+}
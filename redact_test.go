@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "shrew.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := newVault(db, 0)
+	v.mu.Lock()
+	v.remember("TOKEN", "s3cr3t-token")
+	v.remember("PREFIX", "s3cr3t")
+	v.remember("SHORT", "abc")
+	v.remember("SAME", "s3cr3t")
+	v.remember("SHREW_MAX_OUTPUT", "32768")
+	v.remember("SHREW_API_KEY", "sk-live-key")
+	v.mu.Unlock()
+
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"nothing here", "nothing here"},
+		{"Authorization: s3cr3t-token", "Authorization: [[vault:TOKEN]]"},
+		{"s3cr3t-token", "[[vault:TOKEN]]"},
+		{"a s3cr3t b", "a [[vault:PREFIX]] b"},
+		{"s3cr3ts3cr3t", "[[vault:PREFIX]][[vault:PREFIX]]"},
+		{"s3cr3t-tokens3cr3t", "[[vault:TOKEN]][[vault:PREFIX]]"},
+		{"abc", "abc"},
+		{"czNjcjN0LXRva2Vu", "[[vault:TOKEN]]"},
+		{"basic czNjcjN0LXRva2Vu=", "basic [[vault:TOKEN]]="},
+		{"?q=s3cr3t", "?q=[[vault:PREFIX]]"},
+		{"s3cr3", "s3cr3"},
+		{`{"ok": false, "size": 32768}`, `{"ok": false, "size": 32768}`},
+		{"key sk-live-key", "key [[vault:SHREW_API_KEY]]"},
+	}
+	for _, tt := range tests {
+		if got := v.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactPlaintextAndDelete(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "shrew.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := newVault(db, 0)
+	if err := db.SaveSecret("PLAIN", []byte("plain-value")); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Redact("x plain-value"); got != "x [[vault:PLAIN]]" {
+		t.Errorf("plaintext secret: got %q", got)
+	}
+	v.Lock()
+	if got := v.Redact("x plain-value"); got != "x [[vault:PLAIN]]" {
+		t.Errorf("after Lock: got %q", got)
+	}
+	if err := v.Delete("PLAIN"); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Redact("x plain-value"); got != "x plain-value" {
+		t.Errorf("after Delete: got %q", got)
+	}
+}
@@ -1,5 +1,12 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
 type ModelProvider struct {
 	ID       string   `json:"id"`
 	Name     string   `json:"name"`
@@ -85,3 +92,112 @@ var ModelRegistry = []ModelProvider{
 		},
 	},
 }
+
+// findProvider returns the registry entry with the given ID.
+func findProvider(id string) (ModelProvider, bool) {
+	for _, p := range ModelRegistry {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return ModelProvider{}, false
+}
+
+// splitModel splits a SHREW_MODEL value of the form "provider/model" into its
+// provider ID and model name. Models without a known provider prefix are
+// treated as OpenAI-compatible.
+func splitModel(model string) (string, string) {
+	if i := strings.Index(model, "/"); i > 0 {
+		if _, ok := findProvider(model[:i]); ok {
+			return model[:i], model[i+1:]
+		}
+	}
+	return "openai", model
+}
+
+// availableProviders returns the registry with model lists resolved against
+// the running configuration. Local providers and the one currently in use are
+// asked which models they actually serve; the hardcoded entries are only a
+// fallback for when discovery fails.
+func availableProviders(cfg Config) []ModelProvider {
+	active, _ := splitModel(cfg.Model)
+	providers := make([]ModelProvider, len(ModelRegistry))
+	copy(providers, ModelRegistry)
+	for i, p := range providers {
+		if p.ID != active && p.ID != "ollama" {
+			continue
+		}
+		pcfg := cfg
+		if p.ID != active {
+			pcfg.APIURL = ""
+			pcfg.APIKey = ""
+		}
+		impl, ok := providerFactories[p.ID]
+		if !ok {
+			continue
+		}
+		provider := impl(pcfg)
+		if !provider.Capabilities().ModelDiscovery {
+			continue
+		}
+		if models, err := discoverModels(provider); err == nil && len(models) > 0 {
+			providers[i].Models = models
+		}
+	}
+	return providers
+}
+
+// discoveryTimeout bounds how long a provider may take to list its models, so
+// an unreachable endpoint cannot hold up the model picker.
+const discoveryTimeout = 5 * time.Second
+
+func discoverModels(provider Provider) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	return provider.ListModels(ctx)
+}
+
+// Provider is implemented once per backend API. Each implementation lives in
+// its own provider_*.go file and registers itself under one or more
+// ModelProvider IDs from init.
+type Provider interface {
+	// Complete returns the assistant's reply to the conversation.
+	Complete(ctx context.Context, req CompletionRequest) (Message, error)
+	// Stream is like Complete but also reports the reply incrementally as it
+	// is generated. Providers without native streaming deliver it in one
+	// piece.
+	Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) (Message, error)
+	// ListModels returns the model names the backend currently serves.
+	ListModels(ctx context.Context) ([]string, error)
+	Capabilities() Capabilities
+}
+
+type CompletionRequest struct {
+	Model    string
+	System   string
+	Messages []Message
+	Tools    []Tool // offered as native tools when non-empty
+}
+
+type Capabilities struct {
+	Streaming      bool `json:"streaming"`
+	ToolCalling    bool `json:"tool_calling"`
+	ModelDiscovery bool `json:"model_discovery"`
+}
+
+var providerFactories = map[string]func(cfg Config) Provider{}
+
+func registerProvider(id string, factory func(cfg Config) Provider) {
+	providerFactories[id] = factory
+}
+
+// newProvider returns the provider selected by cfg.Model together with the
+// bare model name to send to it.
+func newProvider(cfg Config) (Provider, string, error) {
+	id, model := splitModel(cfg.Model)
+	factory, ok := providerFactories[id]
+	if !ok {
+		return nil, "", fmt.Errorf("no implementation for provider %q", id)
+	}
+	return factory(cfg), model, nil
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// repl is the terminal interface. Input is read on its own goroutine so the
+// user can answer approval prompts while the engine is busy with a turn.
+type repl struct {
+	engine *Engine
+	lines  chan string
+
+	mu      sync.Mutex
+	pending []PendingApproval // approval requests waiting for an answer
+	editing bool              // the next line is a replacement command
+}
+
+func newREPL(e *Engine) *repl {
+	return &repl{engine: e, lines: make(chan string)}
+}
+
+func (r *repl) run() {
+	go r.printEvents(r.engine.Subscribe())
+	go r.readLines()
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go r.handleInterrupts(interrupts)
+
+	for {
+		fmt.Print("> ")
+		input, ok := <-r.lines
+		if !ok {
+			return
+		}
+		if r.answerApproval(input) || input == "" {
+			continue
+		}
+		if strings.HasPrefix(input, "/") {
+			r.command(input)
+			continue
+		}
+
+		done := make(chan struct{})
+		go func() {
+			r.engine.Process(input)
+			close(done)
+		}()
+		r.wait(done)
+	}
+}
+
+func (r *repl) readLines() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		r.lines <- scanner.Text()
+	}
+	close(r.lines)
+}
+
+// command runs a slash command typed at the prompt.
+func (r *repl) command(input string) {
+	fields := strings.Fields(input)
+	switch fields[0] {
+	case "/sandbox":
+		if len(fields) > 1 {
+			if fields[1] != "on" && fields[1] != "off" {
+				fmt.Println("Usage: /sandbox [on|off]")
+				return
+			}
+			if err := r.engine.SetSandbox(fields[1] == "on"); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+		}
+		r.engine.mu.Lock()
+		enabled := r.engine.Sandbox
+		r.engine.mu.Unlock()
+		if enabled {
+			fmt.Println("Sandbox is on for this session: commands can only write to the working directory and /tmp.")
+		} else {
+			fmt.Println("Sandbox is off for this session.")
+		}
+	case "/reset":
+		shell := r.engine.ResetShell()
+		fmt.Printf("Shell reset: working directory is %s, variables and functions are cleared.\n", shell.Root)
+	case "/jobs":
+		jobs := r.engine.Jobs()
+		if len(jobs) == 0 {
+			fmt.Println("No background jobs.")
+		}
+		for _, job := range jobs {
+			fmt.Printf("[%s] %s: %s\n", job.ID, job.Info(0).Status, job.Command)
+		}
+	case "/kill":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /kill <job id>")
+			return
+		}
+		job, err := r.engine.Job(fields[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		job.Kill()
+	case "/undo":
+		n := 1
+		if len(fields) > 1 {
+			var err error
+			if n, err = strconv.Atoi(fields[1]); err != nil || n < 1 {
+				fmt.Println("Usage: /undo [number of turns]")
+				return
+			}
+		}
+		turns, err := r.engine.Checkpoints()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(turns) == 0 {
+			fmt.Println("No file changes to undo.")
+			return
+		}
+		from := turns[max(0, len(turns)-n)]
+		// The restored files are reported by the file_op event.
+		if _, err := r.engine.Undo(from.Turn); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Undid the file changes since %q.\n", from.Prompt)
+	case "/unlock":
+		if !r.engine.Vault.Locked() {
+			fmt.Println("The vault is already unlocked.")
+			return
+		}
+		unlockVault(r.engine.Vault, func() (string, bool) {
+			line, ok := <-r.lines
+			return line, ok
+		})
+	case "/lock":
+		r.engine.Vault.Lock()
+		fmt.Println("Vault locked.")
+	default:
+		fmt.Println("Commands: /sandbox [on|off], /reset, /jobs, /kill <job id>, /undo [turns], /unlock, /lock")
+	}
+}
+
+// handleInterrupts makes Ctrl-C cancel the current turn, killing a running
+// command. With nothing to cancel it exits as usual.
+func (r *repl) handleInterrupts(interrupts chan os.Signal) {
+	for range interrupts {
+		if !r.engine.Cancel() {
+			fmt.Println()
+			r.engine.KillJobs()
+			os.Exit(130)
+		}
+		fmt.Println("\n[cancelling]")
+	}
+}
+
+// wait blocks until the current turn is over, answering approval prompts
+// that come up in the meantime.
+func (r *repl) wait(done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case input, ok := <-r.lines:
+			if !ok {
+				<-done
+				return
+			}
+			if !r.answerApproval(input) && input != "" {
+				fmt.Println("(busy: wait for the current turn to finish)")
+			}
+		}
+	}
+}
+
+// answerApproval treats input as the answer to the oldest pending approval
+// request, if there is one.
+func (r *repl) answerApproval(input string) bool {
+	r.mu.Lock()
+	if len(r.pending) == 0 {
+		r.mu.Unlock()
+		return false
+	}
+	p := r.pending[0]
+	editing := r.editing
+	r.editing = false
+	r.mu.Unlock()
+
+	var d ApprovalDecision
+	if editing {
+		if strings.TrimSpace(input) == "" {
+			fmt.Print("Command unchanged. Allow? [y]es / [n]o [reason] / [e]dit / [a]lways: ")
+			return true
+		}
+		d = ApprovalDecision{Approved: true, Command: input}
+	} else {
+		answer, reason, _ := strings.Cut(strings.TrimSpace(input), " ")
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			d = ApprovalDecision{Approved: true}
+		case "a", "always":
+			d = ApprovalDecision{Approved: true, Always: true}
+		case "n", "no":
+			d = ApprovalDecision{Reason: strings.TrimSpace(reason)}
+		case "e", "edit":
+			if p.Kind != "" {
+				fmt.Print("Only commands can be edited. Allow? [y]es / [n]o [reason] / [a]lways: ")
+				return true
+			}
+			r.mu.Lock()
+			r.editing = true
+			r.mu.Unlock()
+			fmt.Printf("Enter the command to run instead of: %s\n> ", p.Command)
+			return true
+		default:
+			fmt.Print("Please answer [y]es / [n]o [reason] / [e]dit / [a]lways: ")
+			return true
+		}
+	}
+
+	r.removePending(p.ID)
+	if err := r.engine.ResolveApproval(p.ID, d); err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	return true
+}
+
+func (r *repl) removePending(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.pending {
+		if p.ID == id {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			if i == 0 {
+				r.editing = false
+			}
+			return
+		}
+	}
+}
+
+func (r *repl) printEvents(events chan Event) {
+	streaming := false
+	for event := range events {
+		switch event.Type {
+		case EventThinking:
+			fmt.Print("...thinking")
+		case EventResponseDelta:
+			if !streaming {
+				fmt.Print("\nshrew: ")
+				streaming = true
+			}
+			fmt.Print(event.Content)
+		case EventExecuting:
+			if event.Rule != "" {
+				fmt.Printf("\n> [run] (rule: %s): %s\n", event.Rule, event.Content)
+			} else {
+				fmt.Printf("\n> [run]: %s\n", event.Content)
+			}
+		case EventCommandOutput:
+			if event.Stream == "stderr" {
+				fmt.Fprintln(os.Stderr, event.Content)
+			} else {
+				fmt.Println(event.Content)
+			}
+		case EventCommandDone:
+			fmt.Printf("[%s]\n", event.Content)
+		case EventJob:
+			fmt.Printf("\n[job] %s\n", event.Content)
+		case EventFileOp:
+			fmt.Printf("\n[file] %s\n", event.Content)
+		case EventOutput:
+			fmt.Printf("[output]: %s\n", event.Content)
+		case EventResponse:
+			if streaming {
+				fmt.Print("\n\n")
+				streaming = false
+			} else {
+				fmt.Printf("\nshrew: %s\n\n", event.Content)
+			}
+		case EventError:
+			streaming = false
+			fmt.Printf("\nError: %s\n", event.Content)
+		case EventApproval:
+			r.mu.Lock()
+			r.pending = append(r.pending, PendingApproval{ID: event.ID, Command: event.Content, Kind: event.Kind})
+			r.mu.Unlock()
+			if event.Kind == ApprovalReveal {
+				fmt.Printf("\n[approval] shrew wants to see the value of the secret %s\nAllow? [y]es / [n]o [reason] / [a]lways: ", event.Content)
+				break
+			}
+			fmt.Printf("\n[approval] shrew wants to run: %s\nAllow? [y]es / [n]o [reason] / [e]dit / [a]lways: ", event.Content)
+		case EventApprovalResolved:
+			r.removePending(event.ID)
+			fmt.Printf("[approval] %s\n", event.Content)
+		case EventVaultDenied:
+			fmt.Printf("\n[vault] %s\n", event.Content)
+		}
+	}
+}
+
+// unlockVault asks for the master passphrase and unlocks the vault, or, when
+// it has none yet, has one chosen and encrypts the vault with it. An empty
+// answer leaves the vault as it is.
+func unlockVault(v *Vault, readLine func() (string, bool)) {
+	if !v.Status().Initialized {
+		passphrase, ok := promptPassphrase("Choose a master passphrase for the vault (Enter to skip): ", readLine)
+		if !ok || passphrase == "" {
+			return
+		}
+		if confirm, _ := promptPassphrase("Repeat it: ", readLine); confirm != passphrase {
+			fmt.Println("The passphrases differ; the vault is unchanged.")
+			return
+		}
+		n, err := v.Setup(passphrase)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Vault encrypted (%d secrets) and unlocked.\n", n)
+		return
+	}
+
+	for tries := 0; tries < 3; tries++ {
+		passphrase, ok := promptPassphrase("Vault passphrase (Enter to skip): ", readLine)
+		if !ok || passphrase == "" {
+			fmt.Println("The vault stays locked; unlock it later with /unlock or in the Web UI.")
+			return
+		}
+		n, err := v.Unlock(passphrase)
+		if err == nil {
+			if n > 0 {
+				fmt.Printf("Vault unlocked; %d secrets stored in plaintext are now encrypted.\n", n)
+			} else {
+				fmt.Println("Vault unlocked.")
+			}
+			return
+		}
+		fmt.Printf("Error: %v\n", err)
+		if !errors.Is(err, errWrongPassphrase) {
+			return
+		}
+	}
+}
+
+// promptPassphrase reads a line typed at the terminal without echoing it.
+func promptPassphrase(prompt string, readLine func() (string, bool)) (string, bool) {
+	fmt.Print(prompt)
+	setEcho(false)
+	defer fmt.Println()
+	defer setEcho(true)
+
+	// Ctrl-C at the prompt must not leave the terminal without echo.
+	interrupts := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupts:
+			setEcho(true)
+			fmt.Println()
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	return readLine()
+}
+
+// readStdinLine reads one line from stdin a byte at a time, so nothing is
+// buffered away from the REPL that reads stdin afterwards.
+func readStdinLine() (string, bool) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 && b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), true
+		}
+		if n == 1 {
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), len(line) > 0
+		}
+	}
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal, where
+// prompting for a passphrase makes sense.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
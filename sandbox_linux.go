@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const sandboxAvailable = true
+
+// sandboxCommand builds a command that runs cmdStr in fresh user, mount, PID
+// and (unless network is allowed) network namespaces. Shrew re-executes
+// itself as the namespace's first process to prepare the file system before
+// starting bash; see runSandboxInit.
+func sandboxCommand(ctx context.Context, cmdStr string, opts CommandOptions) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: %v", err)
+	}
+	dir := opts.SandboxDir
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			return nil, fmt.Errorf("sandbox: %v", err)
+		}
+	}
+	network := "nonet"
+	flags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID)
+	if opts.Network {
+		network = "net"
+	} else {
+		flags |= syscall.CLONE_NEWNET
+	}
+
+	cmd := exec.CommandContext(ctx, exe, sandboxInitArg, network, dir, cmdStr)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Cloneflags: flags,
+		// Root inside the namespace is the user outside of it, which is
+		// what lets the child set up its mounts.
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+	return cmd, nil
+}
+
+// runSandboxInit runs inside the new namespaces: it makes every mount
+// read-only except dir and a private, empty /tmp, mounts a /proc for the new
+// PID namespace, and replaces itself with bash running the command in the
+// directory it was started in, or in dir if that is no longer reachable.
+func runSandboxInit(args []string) {
+	if len(args) != 3 {
+		fmt.Fprintln(os.Stderr, "sandbox: bad arguments")
+		os.Exit(126)
+	}
+	network, dir, cmdStr := args[0], args[1], args[2]
+	wd, _ := os.Getwd()
+	if err := setupSandbox(dir, network == "nonet"); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(126)
+	}
+	if wd != "" {
+		os.Chdir(wd)
+	}
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(127)
+	}
+	err = syscall.Exec(bash, []string{"bash", "-c", cmdStr}, os.Environ())
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	os.Exit(126)
+}
+
+func setupSandbox(dir string, isolated bool) error {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	// Keep our mount changes out of the parent namespace.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %v", err)
+	}
+	// Bind the working directory onto itself first, so it is a mount of
+	// its own that can be made writable again after everything else is
+	// read-only.
+	if err := unix.Mount(dir, dir, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("binding %s: %v", dir, err)
+	}
+	mounts, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	for i, m := range mounts {
+		if slices.ContainsFunc(mounts[i+1:], func(o mountPoint) bool { return o.path == m.path }) {
+			// Hidden under a later mount at the same path.
+			continue
+		}
+		err := remount(m.path, m.flags|unix.MS_RDONLY)
+		switch {
+		case err == nil, m.flags&unix.MS_RDONLY != 0:
+			// Read-only either way.
+		case errors.Is(err, unix.ENOENT):
+			// Hidden under a mount on a parent directory, out of reach.
+		default:
+			return fmt.Errorf("making %s read-only: %v; the command was not run", m.path, err)
+		}
+	}
+	if err := remount(dir, mountFlags(dir, mounts)&^unix.MS_RDONLY); err != nil {
+		return fmt.Errorf("making %s writable: %v", dir, err)
+	}
+	// A private /tmp, unless the working directory is inside it and would
+	// be hidden.
+	if dir != "/tmp" && !strings.HasPrefix(dir, "/tmp/") {
+		if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("mounting /tmp: %v", err)
+		}
+	}
+	if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mounting /proc: %v", err)
+	}
+	if isolated {
+		// The new network namespace only has a loopback device, and it
+		// starts down.
+		loopbackUp()
+	}
+	return os.Chdir(dir)
+}
+
+type mountPoint struct {
+	path  string
+	flags uintptr
+}
+
+// mountPoints lists the mounts visible to this process with the per-mount
+// flags that a bind remount has to preserve.
+func mountPoints() ([]mountPoint, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountPoint
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		m := mountPoint{path: unescapeMountPath(fields[4])}
+		for _, opt := range strings.Split(fields[5], ",") {
+			switch opt {
+			case "ro":
+				m.flags |= unix.MS_RDONLY
+			case "nosuid":
+				m.flags |= unix.MS_NOSUID
+			case "nodev":
+				m.flags |= unix.MS_NODEV
+			case "noexec":
+				m.flags |= unix.MS_NOEXEC
+			case "noatime":
+				m.flags |= unix.MS_NOATIME
+			case "nodiratime":
+				m.flags |= unix.MS_NODIRATIME
+			case "relatime":
+				m.flags |= unix.MS_RELATIME
+			}
+		}
+		mounts = append(mounts, m)
+	}
+	// Parents before children, so a child's flags are not overridden.
+	sort.SliceStable(mounts, func(i, j int) bool { return len(mounts[i].path) < len(mounts[j].path) })
+	return mounts, scanner.Err()
+}
+
+// mountFlags returns the flags of the last mount listed at path.
+func mountFlags(path string, mounts []mountPoint) uintptr {
+	var flags uintptr
+	for _, m := range mounts {
+		if m.path == path {
+			flags = m.flags
+		}
+	}
+	return flags
+}
+
+func remount(path string, flags uintptr) error {
+	return unix.Mount("", path, "", unix.MS_BIND|unix.MS_REMOUNT|flags, "")
+}
+
+// unescapeMountPath decodes the octal escapes mountinfo uses for spaces and
+// other special characters in paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var c byte
+			if _, err := fmt.Sscanf(s[i+1:i+4], "%03o", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func loopbackUp() {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil || unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr) != nil {
+		return
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
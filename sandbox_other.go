@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+const sandboxAvailable = false
+
+func sandboxCommand(ctx context.Context, cmdStr string, opts CommandOptions) (*exec.Cmd, error) {
+	return nil, errors.New("sandbox: only supported on Linux")
+}
+
+func runSandboxInit(args []string) {
+	fmt.Fprintln(os.Stderr, "sandbox: only supported on Linux")
+	os.Exit(126)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	searchDefaultMaxResults = 200
+	searchMaxFileSize       = 10 << 20
+	searchMaxLineLength     = 300
+)
+
+type SearchOptions struct {
+	Pattern    string
+	Dir        string
+	Include    []string // globs a file must match, any of them
+	Exclude    []string // globs for files and directories to skip
+	Context    int      // lines shown before and after each match
+	MaxResults int
+	IgnoreCase bool
+
+	// Allow, when set, is asked about every file and directory; the ones
+	// it refuses are skipped.
+	Allow func(path string, isDir bool) bool
+}
+
+// searchDir walks opts.Dir looking for lines matching the regular expression
+// opts.Pattern, honouring .gitignore files along the way. It does not depend
+// on grep, so it behaves the same on every host. The result is grep-style
+// text: "path:line:text" for matches and "path-line-text" for context.
+func searchDir(opts SearchOptions) (string, error) {
+	expr := opts.Pattern
+	if opts.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %v", err)
+	}
+	if opts.Dir == "" {
+		opts.Dir = "."
+	}
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = searchDefaultMaxResults
+	}
+	include := compileGlobs(opts.Include)
+	exclude := compileGlobs(opts.Exclude)
+
+	var out strings.Builder
+	matches, files := 0, 0
+	ignore := &ignoreList{}
+	truncated := false
+
+	err = filepath.WalkDir(opts.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(opts.Dir, path)
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel != "." {
+				if d.Name() == ".git" || ignore.match(rel, true) || globsMatch(exclude, rel) || opts.Allow != nil && !opts.Allow(path, true) {
+					return filepath.SkipDir
+				}
+			}
+			ignore.load(path, rel)
+			return nil
+		}
+		if !d.Type().IsRegular() || ignore.match(rel, false) || globsMatch(exclude, rel) {
+			return nil
+		}
+		if len(include) > 0 && !globsMatch(include, rel) {
+			return nil
+		}
+		if opts.Allow != nil && !opts.Allow(path, false) {
+			return nil
+		}
+
+		n, stop := searchFile(path, rel, re, opts.Context, opts.MaxResults-matches, &out)
+		if n > 0 {
+			matches += n
+			files++
+		}
+		if stop {
+			truncated = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if matches == 0 {
+		return "No matches found.", nil
+	}
+	summary := fmt.Sprintf("%d matches in %d files", matches, files)
+	if truncated {
+		summary += fmt.Sprintf(" (stopped after %d results)", opts.MaxResults)
+	}
+	return summary + "\n" + strings.TrimRight(out.String(), "\n"), nil
+}
+
+// searchFile appends the matches in one file to out. It returns the number of
+// matching lines and whether the result budget ran out.
+func searchFile(path, rel string, re *regexp.Regexp, context, budget int, out *strings.Builder) (int, bool) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > searchMaxFileSize {
+		return 0, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || isBinary(data) {
+		return 0, false
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), searchMaxFileSize)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	count := 0
+	lastPrinted := -1
+	for i, line := range lines {
+		if !re.MatchString(line) {
+			continue
+		}
+		if count == budget {
+			return count, true
+		}
+		count++
+
+		from := max(i-context, lastPrinted+1)
+		if context > 0 && lastPrinted >= 0 && from > lastPrinted+1 {
+			out.WriteString("--\n")
+		}
+		for j := from; j < i; j++ {
+			fmt.Fprintf(out, "%s-%d-%s\n", rel, j+1, clipLine(lines[j]))
+		}
+		fmt.Fprintf(out, "%s:%d:%s\n", rel, i+1, clipLine(line))
+		lastPrinted = i
+
+		// Trailing context stops short of the next match, which prints
+		// its own leading context.
+		for j := i + 1; j <= i+context && j < len(lines) && !re.MatchString(lines[j]); j++ {
+			fmt.Fprintf(out, "%s-%d-%s\n", rel, j+1, clipLine(lines[j]))
+			lastPrinted = j
+		}
+	}
+	return count, false
+}
+
+func clipLine(line string) string {
+	if len(line) > searchMaxLineLength {
+		return line[:searchMaxLineLength] + " ..."
+	}
+	return line
+}
+
+// isBinary reports whether data looks like a binary file, using the same NUL
+// byte heuristic as git.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// ignoreRule is one line of a .gitignore file.
+type ignoreRule struct {
+	re       *regexp.Regexp
+	base     string // directory of the .gitignore, relative to the search root
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreList accumulates the .gitignore rules found while walking down the
+// tree. Later rules take precedence, as in git.
+type ignoreList struct {
+	rules []ignoreRule
+}
+
+func (l *ignoreList) load(dir, rel string) {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	if rel == "." {
+		rel = ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: rel}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\")
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		l.rules = append(l.rules, rule)
+	}
+}
+
+func (l *ignoreList) match(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range l.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		target := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			target = strings.TrimPrefix(rel, r.base+"/")
+		}
+		if !r.anchored {
+			target = target[strings.LastIndex(target, "/")+1:]
+		}
+		if r.re.MatchString(target) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// globToRegexp translates a gitignore-style glob to a regular expression:
+// "*" and "?" stay within one path segment, "**" crosses segments.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// pathGlob is an include or exclude pattern. Globs without a slash match the
+// file name at any depth; the others match the whole relative path.
+type pathGlob struct {
+	re       *regexp.Regexp
+	fullPath bool
+}
+
+func compileGlobs(globs []string) []pathGlob {
+	var compiled []pathGlob
+	for _, g := range globs {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		re, err := regexp.Compile("^" + globToRegexp(strings.TrimPrefix(g, "./")) + "$")
+		if err != nil {
+			continue
+		}
+		compiled = append(compiled, pathGlob{re: re, fullPath: strings.Contains(g, "/")})
+	}
+	return compiled
+}
+
+func globsMatch(globs []pathGlob, rel string) bool {
+	base := rel[strings.LastIndex(rel, "/")+1:]
+	for _, g := range globs {
+		if g.fullPath && g.re.MatchString(rel) || !g.fullPath && g.re.MatchString(base) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Secret access modes.
+const (
+	// SecretCommands secrets are only usable through [[vault:NAME]]
+	// placeholders in commands; the model never sees the value. This is the
+	// default.
+	SecretCommands = "commands"
+	// SecretApprove secrets may also be revealed to the model with
+	// <vault_get>, each time after the user approves.
+	SecretApprove = "approve"
+)
+
+// SecretAccess is what a secret may be used for. Hosts and Commands bind it
+// to particular commands: with Hosts, every host a command using the secret
+// names, in a URL, as user@host or, in the arguments of a network tool such as
+// curl or ssh, as a bare word that looks like a host name or an IPv4 address,
+// must match one of them, and there has to be one; with Commands, every simple
+// command the placeholder appears in must match one of the patterns, written
+// as in the command policy. Host patterns are globs such as "*.github.com".
+//
+// The bindings are a best-effort check of the command text. A host reached
+// through a variable, a script or a configuration file is not seen, and a
+// file name such as "out.sh" given to curl counts as a host.
+type SecretAccess struct {
+	Mode     string   `json:"mode,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`
+	Commands []string `json:"commands,omitempty"`
+}
+
+var (
+	urlHostRe  = regexp.MustCompile(`(?i)\b[a-z][a-z0-9+.-]*://(?:[^\s/@'"]*@)?(\[[0-9a-f:.]+\]|[^\s/:?#'"\\]+)`)
+	userHostRe = regexp.MustCompile(`(?:^|[\s'"=])[\w.-]+@([\w-]+(?:\.[\w-]+)*)`)
+	bareHostRe = regexp.MustCompile(`(?i)^(?:(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,63}|\d{1,3}(?:\.\d{1,3}){3}|localhost)$`)
+)
+
+// networkTools are the programs whose arguments are searched for bare host
+// names.
+var networkTools = map[string]bool{
+	"curl": true, "wget": true, "http": true, "https": true, "xh": true,
+	"ssh": true, "scp": true, "sftp": true, "rsync": true, "ftp": true,
+	"nc": true, "ncat": true, "netcat": true, "socat": true, "telnet": true,
+	"openssl": true, "ping": true, "dig": true, "nslookup": true, "host": true,
+}
+
+// normalize validates the access and tidies its lists.
+func (a *SecretAccess) normalize() error {
+	switch a.Mode {
+	case "":
+		a.Mode = SecretCommands
+	case SecretCommands, SecretApprove:
+	default:
+		return fmt.Errorf("invalid access mode %q (want %s or %s)", a.Mode, SecretCommands, SecretApprove)
+	}
+	var hosts, commands []string
+	for _, h := range a.Hosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); h == "" {
+			continue
+		}
+		if _, err := path.Match(h, ""); err != nil {
+			return fmt.Errorf("invalid host pattern %q", h)
+		}
+		hosts = append(hosts, h)
+	}
+	for _, c := range a.Commands {
+		if c = normalizeCommand(c); c != "" {
+			commands = append(commands, c)
+		}
+	}
+	a.Hosts, a.Commands = hosts, commands
+	return nil
+}
+
+// checkCommand returns why the secret name may not be used in command, or
+// nil if it may.
+func (a SecretAccess) checkCommand(name, command string) error {
+	if len(a.Hosts) > 0 {
+		hosts := commandHosts(command)
+		if len(hosts) == 0 {
+			return fmt.Errorf("it is bound to the hosts %s and the command names no host", strings.Join(a.Hosts, ", "))
+		}
+		for _, host := range hosts {
+			if !slices.ContainsFunc(a.Hosts, func(p string) bool { ok, _ := path.Match(p, host); return ok }) {
+				return fmt.Errorf("it is bound to the hosts %s and the command reaches %s", strings.Join(a.Hosts, ", "), host)
+			}
+		}
+	}
+	if len(a.Commands) > 0 {
+		placeholder := "[[vault:" + name + "]]"
+		_, simple := splitCommandLine(command)
+		for _, c := range simple {
+			if !strings.Contains(c, placeholder) {
+				continue
+			}
+			if !slices.ContainsFunc(a.Commands, func(p string) bool { return compilePolicyPattern(p).MatchString(c) }) {
+				return fmt.Errorf("it may only be used in commands matching %s, not in %q", strings.Join(a.Commands, ", "), c)
+			}
+		}
+	}
+	return nil
+}
+
+// commandHosts lists the hosts a command names: in URLs, as user@host the way
+// ssh, scp and git write them, and as bare words such as "api.github.com" or
+// "10.0.0.1:8080/path", which curl, wget and nc accept as well.
+func commandHosts(command string) []string {
+	var hosts []string
+	add := func(host string) {
+		if host = strings.ToLower(strings.Trim(host, "[]")); !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	for _, re := range []*regexp.Regexp{urlHostRe, userHostRe} {
+		for _, m := range re.FindAllStringSubmatch(command, -1) {
+			add(m[1])
+		}
+	}
+	// Bare words only count in the arguments of network tools; elsewhere
+	// "main.go" is a file, not a host.
+	for _, segment := range strings.FieldsFunc(command, func(r rune) bool {
+		return strings.ContainsRune(";|&()`\n", r)
+	}) {
+		words := strings.FieldsFunc(segment, func(r rune) bool {
+			return strings.ContainsRune(" \t'\"<>=,", r)
+		})
+		tool := slices.IndexFunc(words, func(w string) bool { return networkTools[strings.TrimSuffix(path.Base(w), ".exe")] })
+		if tool < 0 {
+			continue
+		}
+		for _, word := range words[tool+1:] {
+			if strings.Contains(word, "://") {
+				continue
+			}
+			// host:port/path, and the host:port:address lists of curl's
+			// --resolve and --connect-to.
+			for _, part := range strings.Split(word, ":") {
+				if host, _, _ := strings.Cut(part, "/"); bareHostRe.MatchString(host) {
+					add(host)
+				}
+			}
+		}
+	}
+	return hosts
+}
+
+// Access returns what a secret may be used for.
+func (v *Vault) Access(name string) (SecretAccess, error) {
+	info, err := v.DB.GetSecretInfo(name)
+	if err != nil {
+		return SecretAccess{}, err
+	}
+	access := info.Access
+	if access.Mode == "" {
+		access.Mode = SecretCommands
+	}
+	return access, nil
+}
+
+// SetAccess changes what a secret may be used for. Like Reveal it asks for
+// the master passphrase, so that a command reaching the Web UI's API cannot
+// loosen it.
+func (v *Vault) SetAccess(name string, access SecretAccess, passphrase string) error {
+	if err := access.normalize(); err != nil {
+		return err
+	}
+	if _, err := v.checkPassphrase(passphrase); err != nil {
+		return err
+	}
+	if _, err := v.DB.GetSecret(name); err != nil {
+		return err
+	}
+	return v.DB.UpdateSecretInfo(name, func(info *SecretInfo) {
+		info.Access = access
+	})
+}
+
+// checkSecretUse applies the access of a secret to a command using it. A
+// refusal is reported to the user as an event.
+func (e *Engine) checkSecretUse(name, command string) error {
+	access, err := e.Vault.Access(name)
+	if err != nil {
+		return err
+	}
+	if err := access.checkCommand(name, command); err != nil {
+		return e.denySecret(name, err)
+	}
+	return nil
+}
+
+// authorizeReveal decides whether <vault_get> may hand a secret to the model:
+// only secrets whose access allows it, and only once the user approves. When
+// it may not, refusal is the result to return instead.
+func (e *Engine) authorizeReveal(ctx context.Context, name string) (refusal *ToolResult) {
+	if _, err := e.DB.GetSecret(name); err != nil {
+		// Not found; reported as such by vault_get.
+		return nil
+	}
+	access, err := e.Vault.Access(name)
+	if err != nil {
+		return revealRefused(name, err.Error())
+	}
+	if access.Mode != SecretApprove {
+		err := e.denySecret(name, fmt.Errorf("its access policy only allows using it in commands, as [[vault:%s]]", name))
+		return revealRefused(name, err.Error())
+	}
+	d := e.requestApproval(ctx, ApprovalReveal, name)
+	if !d.Approved {
+		output := fmt.Sprintf("Revealing secret '%s' was rejected by the user.", name)
+		if d.Reason != "" {
+			output += " Reason: " + d.Reason
+		}
+		return revealRefused(name, output)
+	}
+	return nil
+}
+
+func revealRefused(name, output string) *ToolResult {
+	return &ToolResult{Output: fmt.Sprintf("<vault_output key=\"%s\">\n%s\n</vault_output>", name, output), Display: output, Failed: true}
+}
+
+// denySecret tells the user that a use of a secret was refused and returns
+// the error for the model.
+func (e *Engine) denySecret(name string, reason error) error {
+	err := fmt.Errorf("error: secret '%s' may not be used here: %v", name, reason)
+	e.broadcast(Event{Type: EventVaultDenied, ID: name, Content: err.Error()})
+	return err
+}
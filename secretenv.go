@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// secretEnvPrefix starts the names of the environment variables that carry
+// vault secrets into commands. The shell never saves them in its state.
+const secretEnvPrefix = "SHREW_SECRET_"
+
+var (
+	vaultPlaceholderRe = regexp.MustCompile(`\[\[vault:(.*?)\]\]`)
+	heredocRe          = regexp.MustCompile(`^<<(-?)[ \t]*(?:'([^']*)'|"([^"]*)"|\\(\w+)|([\w.-]+))`)
+)
+
+// secretEnvName turns a secret name into the name of its variable.
+func secretEnvName(name string) string {
+	var b strings.Builder
+	b.WriteString(secretEnvPrefix)
+	for _, r := range name {
+		if r < 128 && (r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// secretValues returns the values of an environment list such as
+// CommandOptions.Env.
+func secretValues(env []string) []string {
+	var values []string
+	for _, kv := range env {
+		if _, value, ok := strings.Cut(kv, "="); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+type quoteFrame struct {
+	kind  byte // 0 for plain text, ( for $(...), ` for `...`, or the quote: ' " $
+	depth int  // open parentheses inside a plain frame
+}
+
+type pendingHeredoc struct {
+	delim  string
+	quoted bool // the body is taken literally
+	tabs   bool // <<-, leading tabs are stripped
+}
+
+// bindPlaceholders rewrites the [[vault:NAME]] placeholders of a bash command
+// into references to environment variables, so the values never appear in
+// the command line. Each reference is quoted to suit where the placeholder
+// stands: "${VAR}" in plain text, ${VAR} inside double quotes and unquoted
+// here-documents, and closing and reopening single quotes around it. It
+// returns the command and the secret each variable stands for.
+//
+// A placeholder in a quoted here-document cannot be expanded and is an error.
+func bindPlaceholders(cmd string) (string, map[string]string, error) {
+	vars := map[string]string{}  // variable -> secret
+	names := map[string]string{} // secret -> variable
+	variable := func(name string) string {
+		if v, ok := names[name]; ok {
+			return v
+		}
+		v := secretEnvName(name)
+		for n := 2; vars[v] != ""; n++ {
+			v = fmt.Sprintf("%s_%d", secretEnvName(name), n)
+		}
+		vars[v], names[name] = name, v
+		return v
+	}
+
+	var b strings.Builder
+	stack := []quoteFrame{{}}
+	var heredocs []pendingHeredoc
+	for i := 0; i < len(cmd); {
+		top := &stack[len(stack)-1]
+		var loc []int
+		if strings.HasPrefix(cmd[i:], "[[vault:") {
+			loc = vaultPlaceholderRe.FindStringSubmatchIndex(cmd[i:])
+		}
+		if loc != nil {
+			ref := "${" + variable(cmd[i+loc[2]:i+loc[3]]) + "}"
+			switch top.kind {
+			case '"':
+				b.WriteString(ref)
+			case '\'':
+				b.WriteString(`'"` + ref + `"'`)
+			case '$':
+				b.WriteString(`'"` + ref + `"$'`)
+			default:
+				b.WriteString(`"` + ref + `"`)
+			}
+			i += loc[1]
+			continue
+		}
+
+		c := cmd[i]
+		rest := cmd[i:]
+		switch top.kind {
+		case '\'':
+			if c == '\'' {
+				stack = stack[:len(stack)-1]
+			}
+		case '$':
+			if c == '\\' && i+1 < len(cmd) {
+				b.WriteString(cmd[i : i+2])
+				i += 2
+				continue
+			}
+			if c == '\'' {
+				stack = stack[:len(stack)-1]
+			}
+		case '"':
+			switch {
+			case c == '\\' && i+1 < len(cmd):
+				b.WriteString(cmd[i : i+2])
+				i += 2
+				continue
+			case c == '"':
+				stack = stack[:len(stack)-1]
+			case strings.HasPrefix(rest, "$("):
+				stack = append(stack, quoteFrame{kind: '('})
+				b.WriteString("$(")
+				i += 2
+				continue
+			case c == '`':
+				stack = append(stack, quoteFrame{kind: '`'})
+			}
+		default:
+			switch {
+			case c == '\\' && i+1 < len(cmd):
+				b.WriteString(cmd[i : i+2])
+				i += 2
+				continue
+			case c == '#' && (i == 0 || strings.ContainsRune(" \t\n;&|(", rune(cmd[i-1]))):
+				// A comment, up to the end of the line.
+				end := strings.IndexByte(rest, '\n')
+				if end < 0 {
+					end = len(rest)
+				}
+				b.WriteString(rest[:end])
+				i += end
+				continue
+			case c == '\'':
+				stack = append(stack, quoteFrame{kind: '\''})
+			case c == '"':
+				stack = append(stack, quoteFrame{kind: '"'})
+			case strings.HasPrefix(rest, "$'"):
+				stack = append(stack, quoteFrame{kind: '$'})
+				b.WriteString("$'")
+				i += 2
+				continue
+			case strings.HasPrefix(rest, "$("):
+				stack = append(stack, quoteFrame{kind: '('})
+				b.WriteString("$(")
+				i += 2
+				continue
+			case c == '(':
+				top.depth++
+			case c == ')' && top.depth > 0:
+				top.depth--
+			case c == ')' && top.kind == '(':
+				stack = stack[:len(stack)-1]
+			case c == '`' && top.kind == '`':
+				stack = stack[:len(stack)-1]
+			case c == '`':
+				stack = append(stack, quoteFrame{kind: '`'})
+			case strings.HasPrefix(rest, "<<") && !strings.HasPrefix(rest, "<<<") && top.depth == 0:
+				if m := heredocRe.FindStringSubmatch(rest); m != nil {
+					h := pendingHeredoc{tabs: m[1] == "-", quoted: m[5] == ""}
+					h.delim = m[2] + m[3] + m[4] + m[5]
+					heredocs = append(heredocs, h)
+					b.WriteString(m[0])
+					i += len(m[0])
+					continue
+				}
+			case c == '\n' && len(heredocs) > 0:
+				b.WriteByte('\n')
+				i++
+				var err error
+				if i, err = bindHeredocs(cmd, i, heredocs, &b, variable); err != nil {
+					return "", nil, err
+				}
+				heredocs = nil
+				continue
+			}
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String(), vars, nil
+}
+
+// bindHeredocs copies the bodies of the here-documents that start at cmd[i],
+// rewriting placeholders, and returns where the command continues.
+func bindHeredocs(cmd string, i int, heredocs []pendingHeredoc, b *strings.Builder, variable func(string) string) (int, error) {
+	for _, h := range heredocs {
+		for i < len(cmd) {
+			end := strings.IndexByte(cmd[i:], '\n')
+			if end < 0 {
+				end = len(cmd) - i
+			}
+			line := cmd[i : i+end]
+			next := min(i+end+1, len(cmd))
+			check := line
+			if h.tabs {
+				check = strings.TrimLeft(line, "\t")
+			}
+			if check == h.delim {
+				b.WriteString(cmd[i:next])
+				i = next
+				break
+			}
+			if vaultPlaceholderRe.MatchString(line) {
+				if h.quoted {
+					return 0, fmt.Errorf("error: vault placeholders cannot be used in a quoted here-document (<<'%s'); use an unquoted delimiter", h.delim)
+				}
+				line = vaultPlaceholderRe.ReplaceAllStringFunc(line, func(ph string) string {
+					return "${" + variable(vaultPlaceholderRe.FindStringSubmatch(ph)[1]) + "}"
+				})
+			}
+			b.WriteString(line + cmd[i+end:next])
+			i = next
+		}
+	}
+	return i, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestBindPlaceholders(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		want string
+		vars map[string]string
+	}{
+		{
+			name: "plain",
+			cmd:  "echo [[vault:GH]]",
+			want: `echo "${SHREW_SECRET_GH}"`,
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "double quotes",
+			cmd:  `curl -H "Authorization: Bearer [[vault:GH]]" https://api.github.com`,
+			want: `curl -H "Authorization: Bearer ${SHREW_SECRET_GH}" https://api.github.com`,
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "single quotes",
+			cmd:  `echo 'token=[[vault:GH]];'`,
+			want: `echo 'token='"${SHREW_SECRET_GH}"';'`,
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "ansi-c quotes",
+			cmd:  `echo $'a\'[[vault:GH]]\n'`,
+			want: `echo $'a\''"${SHREW_SECRET_GH}"$'\n'`,
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "substitution in double quotes",
+			cmd:  `echo "$(printf '%s' [[vault:GH]])"`,
+			want: `echo "$(printf '%s' "${SHREW_SECRET_GH}")"`,
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "escaped quote",
+			cmd:  `echo \'[[vault:GH]]`,
+			want: `echo \'"${SHREW_SECRET_GH}"`,
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "unquoted heredoc",
+			cmd:  "cat <<EOF\ntoken=[[vault:GH]]\nEOF\necho '[[vault:GH]]'",
+			want: "cat <<EOF\ntoken=${SHREW_SECRET_GH}\nEOF\necho ''\"${SHREW_SECRET_GH}\"''",
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "heredoc with tabs",
+			cmd:  "cat <<-END\n\tk=[[vault:GH]]\n\tEND",
+			want: "cat <<-END\n\tk=${SHREW_SECRET_GH}\n\tEND",
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "here-string",
+			cmd:  "cat <<< [[vault:GH]]",
+			want: `cat <<< "${SHREW_SECRET_GH}"`,
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "comment",
+			cmd:  "echo hi # [[vault:GH]]",
+			want: "echo hi # [[vault:GH]]",
+			vars: map[string]string{},
+		},
+		{
+			name: "same secret twice",
+			cmd:  "echo [[vault:GH]] '[[vault:GH]]'",
+			want: `echo "${SHREW_SECRET_GH}" ''"${SHREW_SECRET_GH}"''`,
+			vars: map[string]string{"SHREW_SECRET_GH": "GH"},
+		},
+		{
+			name: "names that map to the same variable",
+			cmd:  "echo [[vault:a-b]] [[vault:a.b]]",
+			want: `echo "${SHREW_SECRET_a_b}" "${SHREW_SECRET_a_b_2}"`,
+			vars: map[string]string{"SHREW_SECRET_a_b": "a-b", "SHREW_SECRET_a_b_2": "a.b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, vars, err := bindPlaceholders(tt.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+			if !maps.Equal(vars, tt.vars) {
+				t.Errorf("vars = %v, want %v", vars, tt.vars)
+			}
+		})
+	}
+}
+
+func TestBindPlaceholdersQuotedHeredoc(t *testing.T) {
+	for _, cmd := range []string{
+		"cat <<'EOF'\n[[vault:GH]]\nEOF",
+		"cat <<\"EOF\"\n[[vault:GH]]\nEOF",
+		"cat <<\\EOF\n[[vault:GH]]\nEOF",
+		"cat <<A <<'B'\nfine [[vault:GH]]\nA\nnot [[vault:GH]]\nB",
+	} {
+		if _, _, err := bindPlaceholders(cmd); err == nil || !strings.Contains(err.Error(), "quoted here-document") {
+			t.Errorf("bindPlaceholders(%q) error = %v, want a quoted here-document error", cmd, err)
+		}
+	}
+}
+
+// TestBindPlaceholdersBash runs the rewritten commands to check that each
+// placeholder expands to the value exactly, whatever it contains.
+func TestBindPlaceholdersBash(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	const value = `a 'b' "c" $d \e *`
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"printf '%s' [[vault:S]]", value},
+		{`printf '%s' "x[[vault:S]]x"`, "x" + value + "x"},
+		{`printf '%s' 'x[[vault:S]]x'`, "x" + value + "x"},
+		{`printf '%s' $'x[[vault:S]]\x41'`, "x" + value + "A"},
+		{"printf '%s' \"$(printf '%s' [[vault:S]])\"", value},
+		{"cat <<EOF\nx[[vault:S]]x\nEOF", "x" + value + "x\n"},
+	}
+	for _, tt := range tests {
+		cmd, vars, err := bindPlaceholders(tt.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := exec.Command(bash, "-c", cmd)
+		c.Env = os.Environ()
+		for v := range vars {
+			c.Env = append(c.Env, v+"="+value)
+		}
+		out, err := c.Output()
+		if err != nil {
+			t.Errorf("%s: %v", cmd, err)
+			continue
+		}
+		if string(out) != tt.want {
+			t.Errorf("%s printed %q, want %q", cmd, out, tt.want)
+		}
+	}
+}
+
+func TestHoldsSecret(t *testing.T) {
+	secrets := []string{"topsecret", "abc"}
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"topsecret", true},
+		{"Bearer topsecret", true},
+		{"abc", true},
+		{"abcdef", false},
+		{"public", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := holdsSecret(tt.text, secrets); got != tt.want {
+			t.Errorf("holdsSecret(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,26 +22,103 @@ var uiFS embed.FS
 
 type Server struct {
 	Engine *Engine
-	mu     sync.Mutex
-	subs   []chan Event
+	// Token is required by every API request; see guard.
+	Token    string
+	loopback bool
+	mu       sync.Mutex
+	subs     []chan Event
 }
 
 func NewServer(e *Engine) *Server {
-	return &Server{Engine: e}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return &Server{Engine: e, Token: hex.EncodeToString(token)}
+}
+
+// URL is the address of the Web UI, with the token that gives access to it.
+func (s *Server) URL(host string, port int) string {
+	if host == "" || isLoopback(host) {
+		host = "localhost"
+	}
+	return fmt.Sprintf("http://%s/?token=%s", net.JoinHostPort(host, strconv.Itoa(port)), s.Token)
 }
 
-func (s *Server) Start(port int) error {
+// Start serves the Web UI and its API on host, which is 127.0.0.1 unless the
+// user asks for another interface.
+func (s *Server) Start(host string, port int) error {
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	s.loopback = isLoopback(host)
 	http.HandleFunc("/", s.handleUI)
-	http.HandleFunc("/events", s.handleEvents)
-	http.HandleFunc("/chat", s.handleChat)
-	http.HandleFunc("/sessions", s.handleListSessions)
-	http.HandleFunc("/session", s.handleSessionRoute)
-	http.HandleFunc("/session/new", s.handleNewSession)
-	http.HandleFunc("/vault", s.handleVault)
-	http.HandleFunc("/skills", s.handleSkills)
+	http.HandleFunc("/events", s.guard(s.handleEvents))
+	http.HandleFunc("/chat", s.guard(s.handleChat))
+	http.HandleFunc("/sessions", s.guard(s.handleListSessions))
+	http.HandleFunc("/session", s.guard(s.handleSessionRoute))
+	http.HandleFunc("/session/new", s.guard(s.handleNewSession))
+	http.HandleFunc("/vault", s.guard(s.handleVault))
+	http.HandleFunc("/vault/status", s.guard(s.handleVaultStatus))
+	http.HandleFunc("/vault/unlock", s.guard(s.handleVaultUnlock))
+	http.HandleFunc("/vault/lock", s.guard(s.handleVaultLock))
+	http.HandleFunc("/vault/reveal", s.guard(s.handleVaultReveal))
+	http.HandleFunc("/vault/access", s.guard(s.handleVaultAccess))
+	http.HandleFunc("/config", s.guard(s.handleConfig))
+	http.HandleFunc("/skills", s.guard(s.handleSkills))
+	http.HandleFunc("/models", s.guard(s.handleModels))
+	http.HandleFunc("/approve", s.guard(s.handleApprove))
+	http.HandleFunc("/policy", s.guard(s.handlePolicy))
+	http.HandleFunc("/cancel", s.guard(s.handleCancel))
+	http.HandleFunc("/sandbox", s.guard(s.handleSandbox))
+	http.HandleFunc("/shell", s.guard(s.handleShell))
+	http.HandleFunc("/shell/reset", s.guard(s.handleShellReset))
+	http.HandleFunc("/jobs", s.guard(s.handleJobs))
+	http.HandleFunc("/jobs/kill", s.guard(s.handleKillJob))
+	http.HandleFunc("/checkpoints", s.guard(s.handleCheckpoints))
+	http.HandleFunc("/checkpoints/undo", s.guard(s.handleUndo))
+
+	fmt.Printf("Web UI available at %s\n", s.URL(host, port))
+	return http.ListenAndServe(net.JoinHostPort(host, strconv.Itoa(port)), nil)
+}
 
-	fmt.Printf("Web UI available at http://localhost:%d\n", port)
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+// guard protects an API endpoint. A request must carry the token of this run
+// in the X-Shrew-Token header, or as the token parameter for EventSource,
+// which cannot set headers; other pages and programs on the machine do not
+// know it. While listening on loopback, the Host header must name it too, so
+// a site that rebinds its DNS name to 127.0.0.1 is turned away.
+func (s *Server) guard(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.loopback {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			if !isLoopback(host) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		token := r.Header.Get("X-Shrew-Token")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			http.Error(w, "Unauthorized: open the Web UI with the link printed at startup", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// isLoopback reports whether host names this machine only.
+func isLoopback(host string) bool {
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
 func (s *Server) handleSessionRoute(w http.ResponseWriter, r *http.Request) {
@@ -79,18 +163,27 @@ func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	s.Engine.KillJobs()
 	s.Engine.mu.Lock()
 	s.Engine.SessionID = sess.ID
 	s.Engine.History = sess.Messages
+	s.Engine.Sandbox = s.Engine.Config.Sandbox && sandboxAvailable
+	if sess.Sandbox != nil {
+		s.Engine.Sandbox = *sess.Sandbox
+	}
+	s.Engine.Shell = newShell(s.Engine.Shell.Root)
 	s.Engine.mu.Unlock()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(sess)
 }
 
 func (s *Server) handleNewSession(w http.ResponseWriter, r *http.Request) {
+	s.Engine.KillJobs()
 	s.Engine.mu.Lock()
 	s.Engine.SessionID = time.Now().Format("2006-01-02-15-04-05")
 	s.Engine.History = []Message{{Role: "user", Content: "Context: " + gatherContext()}}
+	s.Engine.Sandbox = s.Engine.Config.Sandbox && sandboxAvailable
+	s.Engine.Shell = newShell(s.Engine.Shell.Root)
 	s.Engine.mu.Unlock()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -100,7 +193,12 @@ func (s *Server) handleNewSession(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleVault(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		secrets, _ := s.Engine.DB.ListSecrets()
+		// Names and metadata only; values need /vault/reveal.
+		secrets, err := s.Engine.Vault.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(secrets)
 	case http.MethodPost:
@@ -109,28 +207,182 @@ func (s *Server) handleVault(w http.ResponseWriter, r *http.Request) {
 			Value string `json:"value"`
 		}
 		json.NewDecoder(r.Body).Decode(&req)
-		s.Engine.DB.SaveSecret(req.Key, req.Value)
-
-		// Sync with .env and engine if it's a config key
-		configKeys := map[string]bool{
-			"SHREW_API_KEY":             true,
-			"SHREW_API_URL":             true,
-			"SHREW_MODEL":               true,
-			"SHREW_CUSTOM_INSTRUCTIONS": true,
+		if safetyKeys[req.Key] {
+			http.Error(w, req.Key+" can only be set in the environment or .env", http.StatusForbidden)
+			return
 		}
+		// Settings go to .env and the engine; they do not need the vault
+		// unlocked.
 		if configKeys[req.Key] {
+			if err := saveEnv(req.Key, req.Value); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 			s.Engine.UpdateConfig(req.Key, req.Value)
-			saveEnv(req.Key, req.Value)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if err := s.Engine.Vault.Set(req.Key, req.Value); err != nil {
+			http.Error(w, err.Error(), vaultErrorStatus(err))
+			return
 		}
 
 		w.WriteHeader(http.StatusCreated)
 	case http.MethodDelete:
 		key := r.URL.Query().Get("key")
-		s.Engine.DB.DeleteSecret(key)
+		s.Engine.Vault.Delete(key)
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
+// configKeys are the settings the Web UI may change through /vault. They are
+// saved to .env rather than the vault.
+var configKeys = map[string]bool{
+	"SHREW_API_KEY":             true,
+	"SHREW_API_URL":             true,
+	"SHREW_MODEL":               true,
+	"SHREW_CUSTOM_INSTRUCTIONS": true,
+	"SHREW_NATIVE_TOOLS":        true,
+	"SHREW_STOP_ON_ERROR":       true,
+	"SHREW_COMMAND_TIMEOUT":     true,
+	"SHREW_MAX_OUTPUT":          true,
+}
+
+// safetyKeys turn approvals and the sandbox on for the whole run. They are
+// left to whoever starts Shrew, so that a request to the API cannot switch
+// them off.
+var safetyKeys = map[string]bool{
+	"SHREW_APPROVAL": true,
+	"SHREW_SANDBOX":  true,
+}
+
+// vaultErrorStatus is the HTTP status for an error from the vault: 423 Locked
+// when it needs a passphrase first.
+func vaultErrorStatus(err error) int {
+	if errors.Is(err, errVaultLocked) || errors.Is(err, errVaultNoKey) {
+		return http.StatusLocked
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *Server) handleVaultStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Engine.Vault.Status())
+}
+
+// handleVaultUnlock unlocks the vault with the master passphrase, or sets the
+// passphrase when there is none yet.
+func (s *Server) handleVaultUnlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	vault := s.Engine.Vault
+	var err error
+	if vault.Status().Initialized {
+		_, err = vault.Unlock(req.Passphrase)
+	} else {
+		_, err = vault.Setup(req.Passphrase)
+	}
+	if errors.Is(err, errWrongPassphrase) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vault.Status())
+}
+
+// handleVaultReveal returns the value of one secret. The request has to carry
+// the master passphrase: an unlocked vault alone does not reveal values to
+// whatever can reach the server.
+func (s *Server) handleVaultReveal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Key        string `json:"key"`
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	value, err := s.Engine.Vault.Reveal(req.Key, req.Passphrase)
+	switch {
+	case errors.Is(err, errWrongPassphrase):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, errVaultNoKey):
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"key": req.Key, "value": value})
+}
+
+// handleVaultAccess changes what a secret may be used for. Like a reveal, it
+// needs the master passphrase.
+func (s *Server) handleVaultAccess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Key        string       `json:"key"`
+		Passphrase string       `json:"passphrase"`
+		Access     SecretAccess `json:"access"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	err := s.Engine.Vault.SetAccess(req.Key, req.Access, req.Passphrase)
+	switch {
+	case errors.Is(err, errWrongPassphrase):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, errVaultNoKey):
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	access, _ := s.Engine.Vault.Access(req.Key)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(access)
+}
+
+func (s *Server) handleVaultLock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.Engine.Vault.Lock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Engine.Vault.Status())
+}
+
 func (s *Server) handleSkills(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -189,6 +441,219 @@ func (s *Server) handleSkills(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleConfig reports the settings shown in the Vault "System Config" tab.
+// The API key is only reported as set or not.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.Engine.mu.Lock()
+	cfg := s.Engine.Config
+	s.Engine.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_key_set":         cfg.APIKey != "",
+		"api_url":             cfg.APIURL,
+		"model":               cfg.Model,
+		"custom_instructions": cfg.CustomInstructions,
+	})
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	s.Engine.mu.Lock()
+	cfg := s.Engine.Config
+	s.Engine.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(availableProviders(cfg))
+}
+
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Engine.PendingApprovals())
+	case http.MethodPost:
+		var req struct {
+			ID string `json:"id"`
+			ApprovalDecision
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := s.Engine.ResolveApproval(req.ID, req.ApprovalDecision); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.Engine.mu.Lock()
+		policy := s.Engine.Policy
+		s.Engine.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policy)
+	case http.MethodPost:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := s.Engine.SavePolicy(data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"cancelled": s.Engine.Cancel()})
+}
+
+func (s *Server) handleSandbox(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := s.Engine.SetSandbox(req.Enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.Engine.mu.Lock()
+	enabled := s.Engine.Sandbox
+	s.Engine.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"enabled": enabled, "available": sandboxAvailable})
+}
+
+// handleShell reports the state of the session's persistent shell.
+func (s *Server) handleShell(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.Engine.mu.Lock()
+	shell := s.Engine.Shell
+	s.Engine.mu.Unlock()
+	writeShell(w, shell)
+}
+
+func (s *Server) handleShellReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeShell(w, s.Engine.ResetShell())
+}
+
+func writeShell(w http.ResponseWriter, shell *Shell) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"cwd": shell.Dir(), "virtualenv": shell.Virtualenv()})
+}
+
+// handleJobs lists the session's background jobs with the end of their
+// output.
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	infos := []JobInfo{}
+	for _, job := range s.Engine.Jobs() {
+		infos = append(infos, job.Info(50))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+func (s *Server) handleKillJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	job, err := s.Engine.Job(req.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	job.Kill()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.Info(50))
+}
+
+// handleCheckpoints lists the turns of the session whose file changes can be
+// undone.
+func (s *Server) handleCheckpoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	turns, err := s.Engine.Checkpoints()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if turns == nil {
+		turns = []CheckpointTurn{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(turns)
+}
+
+// handleUndo rolls back the file changes made since the given turn.
+func (s *Server) handleUndo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Turn int `json:"turn"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	restored, err := s.Engine.Undo(req.Turn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"restored": restored})
+}
+
 func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 	if path == "/" {
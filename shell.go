@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// shellPrelude runs before every command in a persistent shell. It records
+// the shell's state on fd 3 when bash exits: the working directory, the
+// exported variables and the shell functions, NUL-separated, with an empty
+// entry between the variables and the functions. The variables carrying
+// secrets are left out, so they are never written down. BASH_ENV is cleared
+// so bash scripts started by the command do not run it too.
+const shellPrelude = `unset BASH_ENV
+exec 4<&-
+__shrew_save_state() {
+	local status=$? name IFS=$'\n'
+	{
+		printf '%s\0' "$PWD"
+		for name in $(compgen -e); do
+			[[ $name == ` + secretEnvPrefix + `* ]] && continue
+			printf '%s=%s\0' "$name" "${!name}"
+		done
+		printf '\0'
+		declare -f
+	} >&3 2>/dev/null
+	return $status
+}
+trap __shrew_save_state EXIT
+`
+
+// Variables bash maintains itself, which are not part of the saved state.
+var shellVolatileEnv = map[string]bool{"_": true, "SHLVL": true, "PWD": true, "BASH_ENV": true}
+
+// Shell is the state the commands of a session share, as if they were typed
+// into one terminal: the working directory, exported variables (an activated
+// virtualenv is both) and shell functions. Every command runs in a fresh bash
+// that starts from this state and saves it again when it exits, so a command
+// that is killed leaves the state as it was.
+type Shell struct {
+	Root string // the workspace the shell starts in
+
+	mu    sync.Mutex
+	dir   string
+	env   []string
+	funcs string
+}
+
+// workspaceRoot is the directory shrew was started in, where new shells
+// start and which the sandbox leaves writable.
+func workspaceRoot() string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return wd
+}
+
+func newShell(root string) *Shell {
+	s := &Shell{Root: root, dir: root}
+	for _, kv := range commandEnviron() {
+		if name, _, _ := strings.Cut(kv, "="); !shellVolatileEnv[name] {
+			s.env = append(s.env, kv)
+		}
+	}
+	return s
+}
+
+// commandEnviron returns the environment commands start from: shrew's own,
+// without its settings. SHREW_API_KEY and the others, which .env loads into
+// it, are none of the commands' business.
+func commandEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); !shrewSetting(name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// shrewSetting reports whether an environment variable is one of shrew's
+// settings or secret variables.
+func shrewSetting(name string) bool {
+	return strings.HasPrefix(strings.ToUpper(name), "SHREW_")
+}
+
+// Dir returns the shell's working directory.
+func (s *Shell) Dir() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dir
+}
+
+// Virtualenv returns the Python virtualenv activated in the shell, if any.
+func (s *Shell) Virtualenv() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, kv := range s.env {
+		if v, ok := strings.CutPrefix(kv, "VIRTUAL_ENV="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// describe summarises the shell for the system prompt.
+func (s *Shell) describe() string {
+	text := "SHELL:\nCommands run in a persistent shell: cd, exported variables, functions and activated virtualenvs carry over to the next <run>.\nCurrent working directory: " + s.Dir()
+	if venv := s.Virtualenv(); venv != "" {
+		text += "\nActive virtualenv: " + venv
+	}
+	return text
+}
+
+// prepare sets cmd up to run in the shell: in its working directory, with its
+// environment, and with the prelude and the saved functions loaded through
+// BASH_ENV. It returns the file the command's state will be written to; the
+// caller passes it to update once the command has finished, then calls
+// cleanup.
+func (s *Shell) prepare(cmd *exec.Cmd) (state *os.File, cleanup func(), err error) {
+	s.mu.Lock()
+	dir, env, funcs := s.dir, s.env, s.funcs
+	s.mu.Unlock()
+
+	// A directory removed since the last command falls back to the root.
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = s.Root
+	}
+	cmd.Dir = dir
+	cmd.Env = append(append([]string{}, env...), "PWD="+dir)
+	// Extra files are not passed on to Windows processes; commands there
+	// keep the working directory and environment only.
+	if runtime.GOOS == "windows" {
+		return nil, func() {}, nil
+	}
+
+	if state, err = os.CreateTemp("", "shrew-state-*"); err != nil {
+		return nil, nil, fmt.Errorf("shell state: %v", err)
+	}
+	rc, err := os.CreateTemp("", "shrew-rc-*")
+	if err != nil {
+		state.Close()
+		os.Remove(state.Name())
+		return nil, nil, fmt.Errorf("shell state: %v", err)
+	}
+	cleanup = func() {
+		state.Close()
+		rc.Close()
+		os.Remove(state.Name())
+		os.Remove(rc.Name())
+	}
+	if _, err := io.WriteString(rc, funcs+"\n"+shellPrelude); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("shell state: %v", err)
+	}
+	rc.Seek(0, io.SeekStart)
+
+	// The files are passed as descriptors rather than paths so they stay
+	// reachable from inside the sandbox, where /tmp is a different directory.
+	cmd.ExtraFiles = []*os.File{state, rc}
+	cmd.Env = append(cmd.Env, "BASH_ENV=/dev/fd/4")
+	return state, cleanup, nil
+}
+
+// update reads the state a command saved on exit. A command that was killed
+// saved nothing, and the previous state is kept. secrets are the values the
+// command was given from the vault: variables holding one, such as
+// export T="$SHREW_SECRET_X", are dropped, and functions holding one are not
+// saved, so a secret does not outlive the command that used it.
+func (s *Shell) update(state *os.File, secrets []string) {
+	if state == nil {
+		return
+	}
+	data, err := os.ReadFile(state.Name())
+	if err != nil || len(data) == 0 {
+		return
+	}
+	dirEnd := bytes.IndexByte(data, 0)
+	envEnd := bytes.Index(data, []byte("\x00\x00"))
+	if dirEnd < 0 || envEnd < dirEnd {
+		return
+	}
+	dir := string(data[:dirEnd])
+	if !filepath.IsAbs(dir) {
+		return
+	}
+	var env []string
+	for _, kv := range strings.Split(string(data[dirEnd+1:envEnd+1]), "\x00") {
+		name, _, ok := strings.Cut(kv, "=")
+		if ok && !shellVolatileEnv[name] && !shrewSetting(name) && !holdsSecret(kv[len(name)+1:], secrets) {
+			env = append(env, kv)
+		}
+	}
+	funcs := string(data[envEnd+2:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if holdsSecret(funcs, secrets) {
+		funcs = s.funcs
+	}
+	s.dir, s.env, s.funcs = dir, env, funcs
+}
+
+// holdsSecret reports whether text contains one of the secret values. Values
+// too short to redact only count when they are the whole text.
+func holdsSecret(text string, secrets []string) bool {
+	for _, secret := range secrets {
+		if secret != "" && (text == secret || len(secret) >= minRedactLength && strings.Contains(text, secret)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewShellLeavesOutSettings(t *testing.T) {
+	t.Setenv("SHREW_API_KEY", "sk-test")
+	t.Setenv("SHREW_MODEL", "openai/gpt-4o")
+	t.Setenv("SHREW_TEST_OTHER", "x")
+	t.Setenv("PLAIN_TEST_VAR", "kept")
+	s := newShell(t.TempDir())
+	for _, kv := range s.env {
+		if strings.HasPrefix(kv, "SHREW_") {
+			t.Errorf("shell environment holds %s", kv)
+		}
+	}
+	if !slices.Contains(s.env, "PLAIN_TEST_VAR=kept") {
+		t.Errorf("shell environment lost PLAIN_TEST_VAR")
+	}
+}
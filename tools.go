@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tool describes one engine action. The same actions are reachable through
+// the XML tag protocol in the system prompt and, for providers that support
+// it, through native tool calling.
+type Tool struct {
+	Name        string
+	Description string
+	Params      []ToolParam
+}
+
+type ToolParam struct {
+	Name        string
+	Type        string // JSON schema type, "string" when empty
+	Description string
+	Optional    bool
+}
+
+// ToolResult is what an action produced: Output goes back to the model,
+// Display is the short form shown to the user, empty when the action already
+// showed its output while it ran.
+type ToolResult struct {
+	Output  string
+	Display string
+	Failed  bool
+
+	reveal string // Output with the secret a vault_get revealed, for the model only
+}
+
+var engineTools = []Tool{
+	{
+		Name:        "run_command",
+		Description: "Execute a shell command with bash. Secrets can be referenced as [[vault:NAME]] placeholders. Commands are killed when they time out.",
+		Params: []ToolParam{
+			{Name: "command", Description: "The command line to run."},
+			{Name: "timeout", Type: "integer", Description: "Seconds to let the command run, overriding the default.", Optional: true},
+		},
+	},
+	{
+		Name:        "job_start",
+		Description: "Start a long-running shell command in the background, such as a dev server or a file watcher, and return its job id. The command is not subject to the timeout.",
+		Params:      []ToolParam{{Name: "command", Description: "The command line to run."}},
+	},
+	{
+		Name:        "job_output",
+		Description: "Read the output a background job has written since the last read, with its status.",
+		Params:      []ToolParam{{Name: "id", Description: "The job id."}},
+	},
+	{
+		Name:        "job_status",
+		Description: "Show the status of one background job, or of all of them.",
+		Params:      []ToolParam{{Name: "id", Description: "The job id; omit to list every job.", Optional: true}},
+	},
+	{
+		Name:        "job_kill",
+		Description: "Stop a background job and everything it started.",
+		Params:      []ToolParam{{Name: "id", Description: "The job id."}},
+	},
+	{
+		Name:        "read_file",
+		Description: "Read a text file, or a range of its lines. Output is limited in size; a partial read reports the lines shown and the total. Binary files are summarised by type, size and SHA-256, and directories are listed.",
+		Params: []ToolParam{
+			{Name: "path", Description: "Path of the file or directory to read."},
+			{Name: "start_line", Type: "integer", Description: "First line to read, from 1; negative counts from the end (-100 for the last 100 lines).", Optional: true},
+			{Name: "end_line", Type: "integer", Description: "Last line to read, inclusive.", Optional: true},
+			{Name: "max_bytes", Type: "integer", Description: "Maximum number of bytes to return, below the default limit.", Optional: true},
+		},
+	},
+	{
+		Name:        "write_file",
+		Description: "Write content to a file, replacing it if it exists.",
+		Params: []ToolParam{
+			{Name: "path", Description: "Path of the file to write."},
+			{Name: "content", Description: "The full new content of the file."},
+		},
+	},
+	{
+		Name:        "edit_file",
+		Description: "Change part of an existing file with search/replace blocks or a unified diff, without rewriting it. Each search text (or the old lines of each hunk) must match the file exactly once.",
+		Params: []ToolParam{
+			{Name: "path", Description: "Path of the file to edit."},
+			{Name: "edits", Description: "One or more blocks of the form \"<<<<<<< SEARCH\\n<exact lines from the file>\\n=======\\n<replacement lines>\\n>>>>>>> REPLACE\", or a unified diff of the file."},
+		},
+	},
+	{
+		Name:        "search_files",
+		Description: "Search file contents recursively for a regular expression. Files ignored by .gitignore and binary files are skipped.",
+		Params: []ToolParam{
+			{Name: "pattern", Description: "Regular expression (RE2 syntax) to look for."},
+			{Name: "path", Description: "Directory to search, default the current directory.", Optional: true},
+			{Name: "include", Description: "Comma-separated globs; only matching files are searched (e.g. \"*.go,*.md\").", Optional: true},
+			{Name: "exclude", Description: "Comma-separated globs for files or directories to skip (e.g. \"vendor/**\").", Optional: true},
+			{Name: "context", Type: "integer", Description: "Lines of context around each match.", Optional: true},
+			{Name: "max_results", Type: "integer", Description: "Maximum number of matching lines to return.", Optional: true},
+			{Name: "ignore_case", Type: "boolean", Description: "Match case-insensitively.", Optional: true},
+		},
+	},
+	{
+		Name:        "vault_get",
+		Description: "Reveal the value of a vault secret, if its access policy allows it and the user approves. Only use this when the value itself is needed; commands should use [[vault:NAME]] placeholders instead.",
+		Params:      []ToolParam{{Name: "key", Description: "Name of the secret."}},
+	},
+	{
+		Name:        "vault_list",
+		Description: "List the names of the secrets stored in the vault, without their values.",
+	},
+	{
+		Name:        "save_skill",
+		Description: "Save documentation for a service so it is available in future sessions.",
+		Params: []ToolParam{
+			{Name: "name", Description: "Name of the service or skill."},
+			{Name: "docs", Description: "The documentation to store."},
+		},
+	},
+	{
+		Name:        "get_skill",
+		Description: "Retrieve previously saved documentation for a service.",
+		Params:      []ToolParam{{Name: "name", Description: "Name of the service or skill."}},
+	},
+}
+
+// Schema returns the JSON schema of the tool's parameters.
+func (t Tool) Schema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, p := range t.Params {
+		typ := p.Type
+		if typ == "" {
+			typ = "string"
+		}
+		properties[p.Name] = map[string]interface{}{"type": typ, "description": p.Description}
+		if !p.Optional {
+			required = append(required, p.Name)
+		}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// decodeToolArgs turns a JSON object of tool arguments into the string map
+// shared with the tag protocol. Non-string values keep their JSON form.
+func decodeToolArgs(raw []byte) map[string]string {
+	args := map[string]string{}
+	var values map[string]interface{}
+	if len(raw) == 0 || json.Unmarshal(raw, &values) != nil {
+		return args
+	}
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			args[k] = s
+			continue
+		}
+		b, _ := json.Marshal(v)
+		args[k] = string(b)
+	}
+	return args
+}
+
+// newToolCallID generates an ID for providers that do not assign their own.
+func newToolCallID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "call_" + hex.EncodeToString(b)
+}
+
+// encodeToolArgs is the inverse of decodeToolArgs, used to replay past tool
+// calls to providers.
+func encodeToolArgs(args map[string]string) json.RawMessage {
+	if args == nil {
+		args = map[string]string{}
+	}
+	b, _ := json.Marshal(args)
+	return b
+}
+
+// flattenToolMessages rewrites native tool traffic into plain user/assistant
+// turns, for sending a conversation to a model that is using the tag
+// protocol.
+func flattenToolMessages(history []Message) []Message {
+	var flat []Message
+	for _, m := range history {
+		switch {
+		case m.Role == "tool":
+			flat = append(flat, Message{Role: "user", Content: m.Content})
+		case len(m.ToolCalls) > 0:
+			var content strings.Builder
+			content.WriteString(m.Content)
+			for _, call := range m.ToolCalls {
+				fmt.Fprintf(&content, "\n[called %s %s]", call.Name, encodeToolArgs(call.Arguments))
+			}
+			flat = append(flat, Message{Role: m.Role, Content: strings.TrimSpace(content.String())})
+		default:
+			flat = append(flat, m)
+		}
+	}
+	return flat
+}
+
+// splitList splits a comma-separated argument, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// label describes a call by its tool name and main argument, for the
+// per-action headings in combined output.
+func (c ToolCall) label() string {
+	for _, t := range engineTools {
+		if t.Name != c.Name || len(t.Params) == 0 {
+			continue
+		}
+		arg := strings.TrimSpace(c.Arguments[t.Params[0].Name])
+		if i := strings.IndexAny(arg, "\r\n"); i >= 0 {
+			arg = arg[:i] + " ..."
+		}
+		return c.Name + ": " + arg
+	}
+	return c.Name
+}
+
+// authorizeCommand applies the command policy to cmdStr, asking the user
+// when the policy says so. It returns the command to run, which the user may
+// have edited before approving it, with a note telling the model so. When the
+// command may not run, refusal is the result to return instead.
+func (e *Engine) authorizeCommand(ctx context.Context, cmdStr string) (command, note string, policy PolicyDecision, refusal *ToolResult) {
+	policy = e.checkPolicy(cmdStr)
+	attrs := ruleAttr(policy.Rule)
+	if policy.Action == PolicyDeny {
+		output := "Command refused by the command policy."
+		if policy.Rule != "" {
+			output = fmt.Sprintf("Command refused by policy rule \"%s\".", policy.Rule)
+		}
+		return "", "", policy, &ToolResult{Output: fmt.Sprintf("<output%s>\n%s\n</output>", attrs, output), Display: output, Failed: true}
+	}
+
+	if policy.Action == PolicyAsk {
+		d := e.requestApproval(ctx, "", cmdStr)
+		if !d.Approved {
+			output := "Command rejected by the user."
+			if d.Reason != "" {
+				output += " Reason: " + d.Reason
+			}
+			return "", "", policy, &ToolResult{Output: fmt.Sprintf("<output%s>\n%s\n</output>", attrs, output), Display: output, Failed: true}
+		}
+		if d.Command != "" && strings.TrimSpace(d.Command) != cmdStr {
+			cmdStr = strings.TrimSpace(d.Command)
+			note = "The user edited the command before running it: " + cmdStr + "\n"
+		}
+	}
+	return cmdStr, note, policy, nil
+}
+
+// commandOptions returns the session's settings for running a command the
+// policy allowed.
+func (e *Engine) commandOptions(policy PolicyDecision) CommandOptions {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return CommandOptions{
+		Timeout:    e.Config.CommandTimeout,
+		MaxOutput:  e.Config.MaxOutput,
+		Shell:      e.Shell,
+		Sandbox:    e.Sandbox,
+		SandboxDir: e.Shell.Root,
+		Network:    policy.Network,
+	}
+}
+
+// refusedFileAccess is the result of a file action the file rules refused.
+func refusedFileAccess(err error) ToolResult {
+	output := fmt.Sprintf("Error: %v.", err)
+	return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: output, Failed: true}
+}
+
+// ruleAttr is the rule attribute of a command's <output>, naming the policy
+// rule that decided it.
+func ruleAttr(rule string) string {
+	if rule == "" {
+		return ""
+	}
+	return fmt.Sprintf(" rule=\"%s\"", html.EscapeString(rule))
+}
+
+// executeTool performs one action, whether it came from a native tool call or
+// from a tag in the model's text. Vault values in the result are replaced
+// with their placeholders before it reaches the model, the session or the
+// user. The value vault_get reveals is only kept aside for the model, for the
+// rest of the turn.
+func (e *Engine) executeTool(ctx context.Context, call ToolCall) ToolResult {
+	result := e.runTool(ctx, call)
+	if call.Name == "vault_get" && !result.Failed {
+		result.reveal = result.Output
+	}
+	result.Output = e.Vault.Redact(result.Output)
+	result.Display = e.Vault.Redact(result.Display)
+	return result
+}
+
+func (e *Engine) runTool(ctx context.Context, call ToolCall) ToolResult {
+	args := call.Arguments
+	switch call.Name {
+	case "run_command":
+		cmdStr, note, policy, refusal := e.authorizeCommand(ctx, strings.TrimSpace(args["command"]))
+		if refusal != nil {
+			return *refusal
+		}
+
+		// Broadcast the command WITH placeholders to keep secrets hidden from user/logs
+		e.broadcast(Event{Type: EventExecuting, Content: cmdStr, Rule: policy.Rule})
+
+		// Resolve placeholders for actual execution
+		resolvedCmd, secrets, err := e.resolveVaultPlaceholders(cmdStr)
+		if err != nil {
+			return ToolResult{Output: err.Error(), Display: "Secret resolution failed.", Failed: true}
+		}
+
+		opts := e.commandOptions(policy)
+		opts.Env = secrets
+		if secs, err := strconv.Atoi(strings.TrimSpace(args["timeout"])); err == nil && secs > 0 {
+			opts.Timeout = time.Duration(secs) * time.Second
+		}
+		opts.OnLine = func(stream, line string) {
+			e.broadcast(Event{Type: EventCommandOutput, Stream: stream, Content: line})
+		}
+		res, err := executeCommand(ctx, resolvedCmd, opts)
+		e.broadcast(Event{Type: EventCommandDone, Content: res.Status, ExitCode: &res.ExitCode, Duration: res.Duration.Seconds()})
+		return ToolResult{Output: note + res.outputXML(ruleAttr(policy.Rule)), Failed: err != nil}
+
+	case "job_start":
+		cmdStr, note, policy, refusal := e.authorizeCommand(ctx, strings.TrimSpace(args["command"]))
+		if refusal != nil {
+			return *refusal
+		}
+		e.broadcast(Event{Type: EventExecuting, Content: "[background] " + cmdStr, Rule: policy.Rule})
+		resolvedCmd, secrets, err := e.resolveVaultPlaceholders(cmdStr)
+		if err != nil {
+			return ToolResult{Output: err.Error(), Display: "Secret resolution failed.", Failed: true}
+		}
+		opts := e.commandOptions(policy)
+		opts.Env = secrets
+		job, err := e.startJob(resolvedCmd, cmdStr, opts)
+		if err != nil {
+			output := fmt.Sprintf("Error starting job: %v", err)
+			return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: output, Failed: true}
+		}
+		output := fmt.Sprintf("Started job %s. Read its output with <job_output id=\"%s\"/> and stop it with <job_kill id=\"%s\"/>.", job.ID, job.ID, job.ID)
+		return ToolResult{Output: fmt.Sprintf("%s<job id=\"%s\"%s>\n%s\n</job>", note, job.ID, ruleAttr(policy.Rule), output), Display: fmt.Sprintf("Started job %s", job.ID)}
+
+	case "job_output":
+		job, err := e.Job(args["id"])
+		if err != nil {
+			return ToolResult{Output: fmt.Sprintf("<output>\nError: %v\n</output>", err), Display: err.Error(), Failed: true}
+		}
+		e.mu.Lock()
+		limit := e.Config.MaxOutput
+		e.mu.Unlock()
+		output := job.unread(limit)
+		if output == "" {
+			output = "(no new output)"
+		}
+		return ToolResult{Output: fmt.Sprintf("<job %s>\n%s\n</job>", job.xmlAttrs(), output), Display: fmt.Sprintf("Read output of job %s", job.ID)}
+
+	case "job_status":
+		jobs := e.Jobs()
+		if id := strings.TrimSpace(args["id"]); id != "" {
+			job, err := e.Job(id)
+			if err != nil {
+				return ToolResult{Output: fmt.Sprintf("<output>\nError: %v\n</output>", err), Display: err.Error(), Failed: true}
+			}
+			jobs = []*Job{job}
+		}
+		if len(jobs) == 0 {
+			return ToolResult{Output: "<jobs>\nNo background jobs.\n</jobs>", Display: "No background jobs"}
+		}
+		var lines []string
+		for _, job := range jobs {
+			info := job.Info(0)
+			lines = append(lines, fmt.Sprintf("[%s] %s: %s", job.ID, info.Status, job.Command))
+		}
+		return ToolResult{Output: fmt.Sprintf("<jobs>\n%s\n</jobs>", strings.Join(lines, "\n")), Display: fmt.Sprintf("Checked %d background jobs", len(jobs))}
+
+	case "job_kill":
+		job, err := e.Job(args["id"])
+		if err != nil {
+			return ToolResult{Output: fmt.Sprintf("<output>\nError: %v\n</output>", err), Display: err.Error(), Failed: true}
+		}
+		wasRunning := job.Running()
+		job.Kill()
+		output := fmt.Sprintf("Job %s had already finished.", job.ID)
+		if wasRunning {
+			output = fmt.Sprintf("Job %s was killed.", job.ID)
+		}
+		e.mu.Lock()
+		limit := e.Config.MaxOutput
+		e.mu.Unlock()
+		if rest := job.unread(limit); rest != "" {
+			output += " Output not read yet:\n" + rest
+		}
+		return ToolResult{Output: fmt.Sprintf("<job %s>\n%s\n</job>", job.xmlAttrs(), output), Display: fmt.Sprintf("Killed job %s", job.ID)}
+
+	case "read_file":
+		path := strings.TrimSpace(args["path"])
+		// Directories are checked as such, so that listing one the file
+		// rules only partly allow still works.
+		abs, err := e.checkPath(path, true)
+		if err != nil {
+			return refusedFileAccess(err)
+		}
+		if info, err := os.Stat(abs); err == nil && info.IsDir() {
+			e.broadcast(Event{Type: EventFileOp, Content: "Listing " + path})
+			output, err := listDir(abs, e.canAccess)
+			if err != nil {
+				output = fmt.Sprintf("Error listing directory: %v", err)
+			}
+			return ToolResult{Output: fmt.Sprintf("<output type=\"directory\">\n%s\n</output>", output), Display: output, Failed: err != nil}
+		}
+		if abs, err = e.checkPath(path, false); err != nil {
+			return refusedFileAccess(err)
+		}
+
+		start, _ := strconv.Atoi(strings.TrimSpace(args["start_line"]))
+		end, _ := strconv.Atoi(strings.TrimSpace(args["end_line"]))
+		e.mu.Lock()
+		limit := e.Config.MaxOutput
+		e.mu.Unlock()
+		if n, err := strconv.Atoi(strings.TrimSpace(args["max_bytes"])); err == nil && n > 0 && (limit == 0 || n < limit) {
+			limit = n
+		}
+		msg := "Reading " + path
+		switch {
+		case end != 0:
+			msg += fmt.Sprintf(" (lines %d-%d)", max(start, 1), end)
+		case start != 0:
+			msg += fmt.Sprintf(" (from line %d)", start)
+		}
+		e.broadcast(Event{Type: EventFileOp, Content: msg})
+		output, attrs, err := readFileRange(abs, ReadOptions{Start: start, End: end, MaxBytes: limit})
+		if err != nil {
+			output = fmt.Sprintf("Error reading file: %v", err)
+		}
+		return ToolResult{Output: fmt.Sprintf("<output%s>\n%s\n</output>", attrs, output), Display: output, Failed: err != nil}
+
+	case "write_file":
+		path := strings.TrimSpace(args["path"])
+		abs, err := e.checkPath(path, false)
+		if err != nil {
+			return refusedFileAccess(err)
+		}
+		if err := e.checkpoint(abs); err != nil {
+			output := fmt.Sprintf("Error writing file: %v", err)
+			return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: output, Failed: true}
+		}
+		e.broadcast(Event{Type: EventFileOp, Content: "Writing " + path})
+		err = os.WriteFile(abs, []byte(args["content"]), 0644)
+		output := "File written successfully"
+		if err != nil {
+			output = fmt.Sprintf("Error writing file: %v", err)
+		}
+		return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: output, Failed: err != nil}
+
+	case "edit_file":
+		path := strings.TrimSpace(args["path"])
+		abs, err := e.checkPath(path, false)
+		if err != nil {
+			return refusedFileAccess(err)
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			output := fmt.Sprintf("Error editing file: %v", err)
+			if os.IsNotExist(err) {
+				output = fmt.Sprintf("Error: %s does not exist; use write_file to create it.", path)
+			}
+			return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: output, Failed: true}
+		}
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			output := fmt.Sprintf("Error editing file: %v", err)
+			return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: output, Failed: true}
+		}
+		edited, n, err := applyEdits(string(data), args["edits"])
+		if err != nil {
+			output := fmt.Sprintf("Error editing %s: %v. The file was not changed.", path, err)
+			return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: fmt.Sprintf("Edit of %s failed", path), Failed: true}
+		}
+		diff := unifiedDiff(path, string(data), edited)
+		if diff == "" {
+			output := fmt.Sprintf("The edits leave %s unchanged.", path)
+			return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: output}
+		}
+		if err := e.checkpoint(abs); err != nil {
+			output := fmt.Sprintf("Error editing file: %v", err)
+			return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: output, Failed: true}
+		}
+		if err := os.WriteFile(abs, []byte(edited), info.Mode().Perm()); err != nil {
+			output := fmt.Sprintf("Error writing file: %v", err)
+			return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: output, Failed: true}
+		}
+		added, removed := diffStat(diff)
+		summary := fmt.Sprintf("Edited %s: %d changes, +%d -%d lines", path, n, added, removed)
+		e.broadcast(Event{Type: EventFileOp, Content: summary + "\n" + diff})
+		return ToolResult{Output: fmt.Sprintf("<output>\n%s\n%s\n</output>", summary, diff)}
+
+	case "search_files":
+		pattern := args["pattern"]
+		dir := strings.TrimSpace(args["path"])
+		if dir == "" {
+			dir = "."
+		}
+		abs, err := e.checkPath(dir, true)
+		if err != nil {
+			return refusedFileAccess(err)
+		}
+		e.broadcast(Event{Type: EventFileOp, Content: "Searching for " + pattern})
+		ctxLines, _ := strconv.Atoi(args["context"])
+		maxResults, _ := strconv.Atoi(args["max_results"])
+		output, err := searchDir(SearchOptions{
+			Pattern:    pattern,
+			Dir:        abs,
+			Include:    splitList(args["include"]),
+			Exclude:    splitList(args["exclude"]),
+			Context:    ctxLines,
+			MaxResults: maxResults,
+			IgnoreCase: args["ignore_case"] == "true",
+			Allow:      e.canAccess,
+		})
+		if err != nil {
+			output = fmt.Sprintf("Error searching: %v", err)
+		}
+		return ToolResult{Output: fmt.Sprintf("<output>\n%s\n</output>", output), Display: strings.SplitN(output, "\n", 2)[0], Failed: err != nil}
+
+	case "vault_get":
+		key := args["key"]
+		if refusal := e.authorizeReveal(ctx, key); refusal != nil {
+			return *refusal
+		}
+		val, err := e.secret(key)
+		output := val
+		if errors.Is(err, errVaultLocked) {
+			output = fmt.Sprintf("Error: Secret '%s' is not available: %v.", key, err)
+		} else if err != nil {
+			output = fmt.Sprintf("Error: Secret '%s' not found in vault.", key)
+		}
+		return ToolResult{Output: fmt.Sprintf("<vault_output key=\"%s\">\n%s\n</vault_output>", key, output), Display: "Retrieved secret from vault: " + key, Failed: err != nil}
+
+	case "vault_list":
+		keys, err := e.Vault.Names()
+		output := "Available vault keys: " + strings.Join(keys, ", ")
+		if err != nil || len(keys) == 0 {
+			output = "No keys found in vault."
+		}
+		return ToolResult{Output: fmt.Sprintf("<vault_keys>\n%s\n</vault_keys>", output), Display: "Listed vault keys"}
+
+	case "save_skill":
+		name := args["name"]
+		if err := e.DB.SaveSkill(name, args["docs"]); err != nil {
+			return ToolResult{Output: fmt.Sprintf("Error saving skill '%s': %v", name, err), Display: "Failed to save skill: " + name, Failed: true}
+		}
+		e.RefreshSystemPrompt()
+		return ToolResult{Output: fmt.Sprintf("Skill '%s' saved successfully.", name), Display: "Learned new skill: " + name}
+
+	case "get_skill":
+		name := args["name"]
+		docs, err := e.DB.GetSkill(name)
+		output := docs
+		if err != nil {
+			output = fmt.Sprintf("Error: Skill '%s' not found.", name)
+		}
+		return ToolResult{Output: fmt.Sprintf("<skill_output name=\"%s\">\n%s\n</skill_output>", name, output), Display: "Retrieved skill docs: " + name, Failed: err != nil}
+	}
+
+	return ToolResult{Output: fmt.Sprintf("Error: unknown tool '%s'.", call.Name), Display: "Unknown tool: " + call.Name, Failed: true}
+}
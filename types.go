@@ -1,8 +1,27 @@
 package main
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
+
+	// reveal is what the model gets instead of Content until the turn
+	// ends: a result with the secret <vault_get> revealed, where Content
+	// has its placeholder. It is never saved.
+	reveal string
+}
+
+type ToolCall struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Arguments map[string]string `json:"arguments"`
 }
 
 type Config struct {
@@ -10,11 +29,20 @@ type Config struct {
 	APIURL             string
 	Model              string
 	CustomInstructions string
+	NativeTools        bool
+	StopOnError        bool
+	RequireApproval    bool
+	PolicyFile         string
+	CommandTimeout     time.Duration
+	MaxOutput          int
+	Sandbox            bool // default for new sessions
+	VaultTimeout       time.Duration
 }
 
 type GeminiRequest struct {
 	SystemInstruction *GeminiContent  `json:"system_instruction,omitempty"`
 	Contents          []GeminiContent `json:"contents"`
+	Tools             []GeminiTool    `json:"tools,omitempty"`
 }
 
 type GeminiContent struct {
@@ -23,15 +51,35 @@ type GeminiContent struct {
 }
 
 type GeminiPart struct {
-	Text string `json:"text"`
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type GeminiFunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type GeminiFunctionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
 }
 
 type GeminiResponse struct {
 	Candidates []struct {
 		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
+			Parts []GeminiPart `json:"parts"`
 		} `json:"content"`
 	} `json:"candidates"`
 }
@@ -40,9 +88,118 @@ type Session struct {
 	ID        string    `json:"id"`
 	Timestamp string    `json:"timestamp"`
 	Messages  []Message `json:"messages"`
+	Sandbox   *bool     `json:"sandbox,omitempty"` // nil for the configured default
+}
+
+// Checkpoint is the content a file had before a file action of the given
+// turn changed it. Turn is the index in the session's messages of the user
+// message that started the turn.
+type Checkpoint struct {
+	Turn    int    `json:"turn"`
+	Path    string `json:"path"`
+	Existed bool   `json:"existed"` // false for a file the action created
+	Content []byte `json:"content,omitempty"`
+	Mode    uint32 `json:"mode,omitempty"`
+	Time    string `json:"time"`
 }
 
 type Skill struct {
 	Name          string `json:"name"`
 	Documentation string `json:"documentation"`
 }
+
+type AnthropicRequest struct {
+	Model     string             `json:"model"`
+	System    string             `json:"system,omitempty"`
+	Messages  []AnthropicMessage `json:"messages"`
+	MaxTokens int                `json:"max_tokens"`
+	Stream    bool               `json:"stream,omitempty"`
+	Tools     []AnthropicTool    `json:"tools,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []AnthropicBlock `json:"content"`
+}
+
+// AnthropicBlock is one content block: text, a tool_use request from the
+// model, or the tool_result answering it.
+type AnthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type AnthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type AnthropicResponse struct {
+	Content []AnthropicBlock `json:"content"`
+}
+
+type OllamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Tools    []OpenAITool    `json:"tools,omitempty"`
+}
+
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type OllamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type OllamaResponse struct {
+	Message OllamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type OllamaTagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+type OpenAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type OpenAIToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// OpenAITool is the function tool definition shared by OpenAI-compatible
+// servers and Ollama.
+type OpenAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string                 `json:"name"`
+		Description string                 `json:"description"`
+		Parameters  map[string]interface{} `json:"parameters"`
+	} `json:"function"`
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -65,6 +64,28 @@ func saveEnv(key, value string) error {
 	return writer.Flush()
 }
 
+// parseSeconds reads a duration setting given in seconds ("90") or as a Go
+// duration ("5m"). Zero means no limit; empty or invalid values give def.
+func parseSeconds(value string, def time.Duration) time.Duration {
+	value = strings.TrimSpace(value)
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d
+	}
+	return def
+}
+
+// parseBytes reads a size setting in bytes. Zero means no limit; empty or
+// invalid values give def.
+func parseBytes(value string, def int) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
+
 func loadSkills(db *DB) string {
 	var skills strings.Builder
 	// Load from files
@@ -102,15 +123,6 @@ func gatherContext() string {
 	return fmt.Sprintf("Working Dir: %s\nFiles (top 100):\n - %s", wd, strings.Join(files, "\n - "))
 }
 
-func executeCommand(cmdStr string) (string, error) {
-	cmd := exec.Command("bash", "-c", cmdStr)
-	var out, stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	return strings.TrimSpace(out.String() + stderr.String()), err
-}
-
 var (
 	debugLog *os.File
 )
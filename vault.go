@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	vaultKDFIterations  = 600000
+	vaultMinPassphrase  = 8
+	defaultVaultTimeout = 15 * time.Minute
+)
+
+var (
+	// sealedPrefix marks an encrypted value: it is followed by the GCM nonce
+	// and the ciphertext. Values written before the vault was encrypted do
+	// not start with it.
+	sealedPrefix = []byte("\x00shrew-vault-v1\x00")
+	// vaultCheckName is the associated data of the check value, chosen so
+	// it cannot be the name of a secret.
+	vaultCheckName = "\x00check"
+
+	errVaultLocked     = errors.New("the vault is locked; unlock it with /unlock in the terminal or in the Web UI vault tab")
+	errVaultNoKey      = errors.New("the vault has no master passphrase yet; set one with /unlock in the terminal or in the Web UI vault tab")
+	errWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// VaultConfig is what is needed to derive the vault key from the master
+// passphrase, and a value encrypted with the key to recognise the right one.
+type VaultConfig struct {
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	Check      []byte `json:"check"`
+}
+
+// SecretInfo describes a secret without its value. Created and Updated are
+// empty for secrets saved before they were recorded.
+type SecretInfo struct {
+	Name     string   `json:"name"`
+	Created  string   `json:"created,omitempty"`
+	Updated  string   `json:"updated,omitempty"`
+	LastUsed string   `json:"last_used,omitempty"`
+	Sessions []string `json:"sessions"` // sessions that used the secret, most recent last
+
+	Access SecretAccess `json:"access"`
+}
+
+// maxSecretSessions bounds the sessions recorded per secret.
+const maxSecretSessions = 20
+
+// VaultStatus is the state of the vault as reported to the Web UI.
+type VaultStatus struct {
+	Initialized bool    `json:"initialized"` // a master passphrase is set
+	Locked      bool    `json:"locked"`
+	Plaintext   int     `json:"plaintext"` // secrets not encrypted yet
+	Timeout     float64 `json:"timeout"`   // seconds of inactivity before re-locking, 0 for never
+}
+
+// Vault stores secrets in shrew.db encrypted with AES-256-GCM, under a key
+// derived from the master passphrase with PBKDF2-SHA256. The secret's name is
+// bound to its value as associated data, so values cannot be swapped between
+// names. Names are stored in clear so they can be listed while locked.
+//
+// The key is only held in memory between Unlock and Lock, and the vault locks
+// itself after Timeout without use. With a keyfile, its content serves as the
+// passphrase and the vault unlocks again whenever a secret is needed.
+//
+// Secrets saved before a passphrase was set are still readable until one is;
+// setting it, and every unlock after, encrypts them.
+type Vault struct {
+	DB      *DB
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	key     []byte
+	keyfile string
+	timer   *time.Timer
+
+	known map[string][]valuePrint // hashes of decrypted values, for Redact
+	index *redactIndex
+}
+
+func newVault(db *DB, timeout time.Duration) *Vault {
+	return &Vault{DB: db, Timeout: timeout}
+}
+
+// Status reports whether the vault has a passphrase, whether it is locked
+// and how many secrets are not encrypted yet.
+func (v *Vault) Status() VaultStatus {
+	cfg, _ := v.DB.GetVaultConfig()
+	secrets, _ := v.DB.ListSecrets()
+	plaintext := 0
+	for _, value := range secrets {
+		if !bytes.HasPrefix(value, sealedPrefix) {
+			plaintext++
+		}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return VaultStatus{
+		Initialized: cfg != nil,
+		Locked:      v.key == nil,
+		Plaintext:   plaintext,
+		Timeout:     v.Timeout.Seconds(),
+	}
+}
+
+// Setup sets the master passphrase of a vault that has none, encrypts the
+// secrets stored so far and leaves the vault unlocked. It returns the number
+// of secrets encrypted.
+func (v *Vault) Setup(passphrase string) (int, error) {
+	if len(passphrase) < vaultMinPassphrase {
+		return 0, fmt.Errorf("the passphrase needs at least %d characters", vaultMinPassphrase)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if cfg, err := v.DB.GetVaultConfig(); err != nil {
+		return 0, err
+	} else if cfg != nil {
+		return 0, fmt.Errorf("the vault already has a master passphrase")
+	}
+
+	cfg := &VaultConfig{Salt: make([]byte, 16), Iterations: vaultKDFIterations}
+	if _, err := rand.Read(cfg.Salt); err != nil {
+		return 0, err
+	}
+	key, err := deriveVaultKey(passphrase, cfg)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.Check, err = sealSecret(key, vaultCheckName, []byte(vaultCheckName)); err != nil {
+		return 0, err
+	}
+	n, err := v.DB.UpdateSecrets(cfg, sealPlaintext(key))
+	if err != nil {
+		return 0, err
+	}
+	v.unlocked(key)
+	return n, nil
+}
+
+// Unlock derives the key from the passphrase and keeps it until the vault is
+// locked again. Secrets still stored in plaintext are encrypted on the way;
+// it returns how many.
+func (v *Vault) Unlock(passphrase string) (int, error) {
+	key, err := v.checkPassphrase(passphrase)
+	if err != nil {
+		return 0, err
+	}
+	n, err := v.DB.UpdateSecrets(nil, sealPlaintext(key))
+	if err != nil {
+		return 0, err
+	}
+	v.mu.Lock()
+	v.unlocked(key)
+	v.mu.Unlock()
+	return n, nil
+}
+
+// checkPassphrase returns the key the passphrase derives if it is the
+// master passphrase.
+func (v *Vault) checkPassphrase(passphrase string) ([]byte, error) {
+	cfg, err := v.DB.GetVaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errVaultNoKey
+	}
+	key, err := deriveVaultKey(passphrase, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := openSecret(key, vaultCheckName, cfg.Check); err != nil || string(check) != vaultCheckName {
+		return nil, errWrongPassphrase
+	}
+	return key, nil
+}
+
+// UseKeyfile unlocks the vault with the content of a keyfile, setting it up
+// with that key if it has no passphrase yet. The vault unlocks itself with
+// the keyfile from then on.
+func (v *Vault) UseKeyfile(path string) error {
+	passphrase, err := readKeyfile(path)
+	if err != nil {
+		return err
+	}
+	if cfg, err := v.DB.GetVaultConfig(); err != nil {
+		return err
+	} else if cfg == nil {
+		_, err = v.Setup(passphrase)
+	} else {
+		_, err = v.Unlock(passphrase)
+	}
+	if err != nil {
+		return fmt.Errorf("keyfile %s: %v", path, err)
+	}
+	v.mu.Lock()
+	v.keyfile = path
+	v.mu.Unlock()
+	return nil
+}
+
+func readKeyfile(path string) (string, error) {
+	data, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// unlocked keeps key and starts the inactivity timer. The caller holds v.mu.
+func (v *Vault) unlocked(key []byte) {
+	v.key = key
+	v.rememberAll(key)
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+	if v.Timeout > 0 {
+		v.timer = time.AfterFunc(v.Timeout, v.Lock)
+	}
+}
+
+// Lock forgets the key. No secret value stays in memory: Redact only keeps
+// their hashes.
+func (v *Vault) Lock() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	clear(v.key)
+	v.key = nil
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+}
+
+// Locked reports whether the vault needs a passphrase before secrets can be
+// read or saved.
+func (v *Vault) Locked() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.key == nil
+}
+
+// currentKey returns the key, unlocking with the keyfile if there is one, and
+// restarts the inactivity timer.
+func (v *Vault) currentKey() ([]byte, error) {
+	v.mu.Lock()
+	keyfile := v.keyfile
+	locked := v.key == nil
+	v.mu.Unlock()
+	if locked && keyfile != "" {
+		if err := v.UseKeyfile(keyfile); err != nil {
+			return nil, err
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return nil, errVaultLocked
+	}
+	if v.timer != nil {
+		v.timer.Reset(v.Timeout)
+	}
+	// A copy, since Lock clears the key.
+	return append([]byte{}, v.key...), nil
+}
+
+// Get returns the value of a secret.
+func (v *Vault) Get(name string) (string, error) {
+	data, err := v.DB.GetSecret(name)
+	if err != nil {
+		return "", err
+	}
+	return v.decrypt(name, data)
+}
+
+func (v *Vault) decrypt(name string, data []byte) (string, error) {
+	if !bytes.HasPrefix(data, sealedPrefix) {
+		// Stored before the vault was encrypted; the next unlock seals it.
+		return string(data), nil
+	}
+	key, err := v.currentKey()
+	if err != nil {
+		return "", err
+	}
+	plain, err := openSecret(key, name, data)
+	if err != nil {
+		return "", fmt.Errorf("secret %s cannot be decrypted: %v", name, err)
+	}
+	v.mu.Lock()
+	v.remember(name, string(plain))
+	v.mu.Unlock()
+	return string(plain), nil
+}
+
+// Set encrypts and stores a secret. The vault needs a passphrase and has to
+// be unlocked.
+func (v *Vault) Set(name, value string) error {
+	if cfg, err := v.DB.GetVaultConfig(); err != nil {
+		return err
+	} else if cfg == nil {
+		return errVaultNoKey
+	}
+	key, err := v.currentKey()
+	if err != nil {
+		return err
+	}
+	data, err := sealSecret(key, name, []byte(value))
+	if err != nil {
+		return err
+	}
+	if err := v.DB.SaveSecret(name, data); err != nil {
+		return err
+	}
+	v.mu.Lock()
+	v.remember(name, value)
+	v.mu.Unlock()
+	now := time.Now().Format(time.RFC3339)
+	return v.DB.UpdateSecretInfo(name, func(info *SecretInfo) {
+		if info.Created == "" {
+			info.Created = now
+		}
+		info.Updated = now
+	})
+}
+
+// Use records that a session used a secret.
+func (v *Vault) Use(name, sessionID string) error {
+	return v.DB.UpdateSecretInfo(name, func(info *SecretInfo) {
+		info.LastUsed = time.Now().Format(time.RFC3339)
+		info.Sessions = slices.DeleteFunc(info.Sessions, func(s string) bool { return s == sessionID })
+		info.Sessions = append(info.Sessions, sessionID)
+		if extra := len(info.Sessions) - maxSecretSessions; extra > 0 {
+			info.Sessions = info.Sessions[extra:]
+		}
+	})
+}
+
+// Reveal returns the value of a secret to the user. It asks for the master
+// passphrase again, so that having reached an unlocked vault is not enough
+// to read its values.
+func (v *Vault) Reveal(name, passphrase string) (string, error) {
+	key, err := v.checkPassphrase(passphrase)
+	if err != nil {
+		return "", err
+	}
+	data, err := v.DB.GetSecret(name)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, sealedPrefix) {
+		return string(data), nil
+	}
+	plain, err := openSecret(key, name, data)
+	if err != nil {
+		return "", fmt.Errorf("secret %s cannot be decrypted: %v", name, err)
+	}
+	return string(plain), nil
+}
+
+func (v *Vault) Delete(name string) error {
+	if err := v.DB.DeleteSecret(name); err != nil {
+		return err
+	}
+	v.mu.Lock()
+	delete(v.known, name)
+	v.index = nil
+	v.mu.Unlock()
+	return nil
+}
+
+// Names lists the secrets, sorted; it works while the vault is locked.
+func (v *Vault) Names() ([]string, error) {
+	secrets, err := v.DB.ListSecrets()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// List describes every secret, sorted by name, without the values.
+func (v *Vault) List() ([]SecretInfo, error) {
+	names, err := v.Names()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := v.DB.ListSecretInfo()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]SecretInfo, 0, len(names))
+	for _, name := range names {
+		info, ok := infos[name]
+		if !ok {
+			info = SecretInfo{Name: name}
+		}
+		if info.Sessions == nil {
+			info.Sessions = []string{}
+		}
+		if info.Access.Mode == "" {
+			info.Access.Mode = SecretCommands
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+func deriveVaultKey(passphrase string, cfg *VaultConfig) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, cfg.Salt, cfg.Iterations, 32)
+}
+
+// sealPlaintext returns an UpdateSecrets function encrypting the values that
+// are not encrypted yet.
+func sealPlaintext(key []byte) func(name string, value []byte) ([]byte, error) {
+	return func(name string, value []byte) ([]byte, error) {
+		if bytes.HasPrefix(value, sealedPrefix) {
+			return nil, nil
+		}
+		return sealSecret(key, name, value)
+	}
+}
+
+func sealSecret(key []byte, name string, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, sealedPrefix...), nonce...)
+	return gcm.Seal(out, nonce, plain, []byte(name)), nil
+}
+
+func openSecret(key []byte, name string, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, sealedPrefix)
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("value too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(name))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}